@@ -0,0 +1,300 @@
+// Package ax25 encodes and decodes AX.25 frames as carried inside KISS
+// data frames. Only what hamirc needs is implemented: addressing (with
+// digipeater paths) and unnumbered information (UI) frames. KISS TNCs
+// add and strip the FCS, so frames here never include it.
+package ax25
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ControlUI is the control field of an unnumbered information
+	// frame with the poll/final bit cleared.
+	ControlUI = 0x03
+
+	// PIDNoLayer3 is the protocol identifier for frames that carry no
+	// layer 3 protocol; this is what APRS and most UI chat uses.
+	PIDNoLayer3 = 0xF0
+
+	// MaxDigipeaters is the maximum number of digipeater addresses an
+	// AX.25 frame may carry.
+	MaxDigipeaters = 8
+
+	addrLen = 7
+)
+
+var (
+	ErrShortFrame   = errors.New("ax25: frame too short")
+	ErrBadAddress   = errors.New("ax25: malformed address")
+	ErrTooManyDigis = errors.New("ax25: too many digipeaters")
+	ErrInvalidCall  = errors.New("ax25: callsign must be 1-6 letters or digits")
+	ErrInvalidSSID  = errors.New("ax25: SSID must be 0-15")
+	ErrMissingPID   = errors.New("ax25: frame is missing its PID")
+)
+
+// Address is an AX.25 station address: a callsign and a secondary
+// station identifier (SSID).
+type Address struct {
+	Call string
+	SSID uint8
+	// Repeated is the has-been-repeated bit. It is only meaningful for
+	// digipeater addresses.
+	Repeated bool
+}
+
+// ParseAddress parses a callsign with an optional -SSID suffix, such as
+// "K1ABC" or "k1abc-7". Callsigns are uppercased. A trailing "*" marks
+// a digipeater address as already repeated, as monitor output does.
+func ParseAddress(s string) (Address, error) {
+	var addr Address
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if rest, ok := strings.CutSuffix(s, "*"); ok {
+		addr.Repeated = true
+		s = rest
+	}
+	call, ssid, hasSSID := strings.Cut(s, "-")
+	if hasSSID {
+		n, err := strconv.Atoi(ssid)
+		if err != nil || n < 0 || n > 15 {
+			return Address{}, fmt.Errorf("%w: %q", ErrInvalidSSID, ssid)
+		}
+		addr.SSID = uint8(n)
+	}
+	if !validCall(call) {
+		return Address{}, fmt.Errorf("%w: %q", ErrInvalidCall, call)
+	}
+	addr.Call = call
+	return addr, nil
+}
+
+// ParsePath parses a comma separated digipeater path such as
+// "WIDE1-1,WIDE2-1".
+func ParsePath(s string) ([]Address, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	if len(fields) > MaxDigipeaters {
+		return nil, ErrTooManyDigis
+	}
+	path := make([]Address, 0, len(fields))
+	for _, field := range fields {
+		addr, err := ParseAddress(field)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, addr)
+	}
+	return path, nil
+}
+
+func validCall(call string) bool {
+	if len(call) == 0 || len(call) > 6 {
+		return false
+	}
+	for _, r := range call {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the address in CALL-SSID form. The SSID is omitted
+// when it is zero.
+func (a Address) String() string {
+	s := a.Call
+	if a.SSID != 0 {
+		s += "-" + strconv.Itoa(int(a.SSID))
+	}
+	if a.Repeated {
+		s += "*"
+	}
+	return s
+}
+
+// encode writes the 7-byte on-air form of the address to buf. bit7 is
+// the C bit for source and destination addresses and the H bit for
+// digipeaters.
+func (a Address) encode(buf []byte, bit7, last bool) error {
+	if !validCall(a.Call) {
+		return fmt.Errorf("%w: %q", ErrInvalidCall, a.Call)
+	}
+	if a.SSID > 15 {
+		return ErrInvalidSSID
+	}
+	for i := range 6 {
+		c := byte(' ')
+		if i < len(a.Call) {
+			c = a.Call[i]
+		}
+		buf[i] = c << 1
+	}
+	buf[6] = 0x60 | a.SSID<<1
+	if bit7 {
+		buf[6] |= 0x80
+	}
+	if last {
+		buf[6] |= 0x01
+	}
+	return nil
+}
+
+func decodeAddress(buf []byte) (addr Address, bit7, last bool, err error) {
+	call := make([]byte, 0, 6)
+	for i := range 6 {
+		if buf[i]&0x01 != 0 {
+			return Address{}, false, false, ErrBadAddress
+		}
+		c := buf[i] >> 1
+		if c == ' ' {
+			continue
+		}
+		call = append(call, c)
+	}
+	addr.Call = string(call)
+	if !validCall(addr.Call) {
+		return Address{}, false, false, fmt.Errorf("%w: %q", ErrBadAddress, addr.Call)
+	}
+	addr.SSID = (buf[6] >> 1) & 0x0F
+	return addr, buf[6]&0x80 != 0, buf[6]&0x01 != 0, nil
+}
+
+// Frame is an AX.25 frame without its FCS.
+type Frame struct {
+	Dst     Address
+	Src     Address
+	Path    []Address
+	Control byte
+	PID     byte
+	Info    []byte
+}
+
+// UI returns an unnumbered information frame from src to dst carrying
+// info with no layer 3 protocol.
+func UI(dst, src Address, info []byte) *Frame {
+	return &Frame{
+		Dst:     dst,
+		Src:     src,
+		Control: ControlUI,
+		PID:     PIDNoLayer3,
+		Info:    info,
+	}
+}
+
+// IsUI reports whether f is an unnumbered information frame.
+func (f *Frame) IsUI() bool {
+	return f.Control&^0x10 == ControlUI
+}
+
+// hasPID reports whether the control field is one that is followed by
+// a PID and information field: I frames and UI frames.
+func hasPID(control byte) bool {
+	return control&0x01 == 0 || control&^0x10 == ControlUI
+}
+
+// MarshalBinary encodes f as it is sent inside a KISS data frame. Frames
+// are encoded as AX.25 v2 commands.
+func (f *Frame) MarshalBinary() ([]byte, error) {
+	if len(f.Path) > MaxDigipeaters {
+		return nil, ErrTooManyDigis
+	}
+	nAddrs := 2 + len(f.Path)
+	buf := make([]byte, nAddrs*addrLen, nAddrs*addrLen+2+len(f.Info))
+	if err := f.Dst.encode(buf[0:], true, false); err != nil {
+		return nil, err
+	}
+	if err := f.Src.encode(buf[addrLen:], false, len(f.Path) == 0); err != nil {
+		return nil, err
+	}
+	for i, digi := range f.Path {
+		last := i == len(f.Path)-1
+		if err := digi.encode(buf[(2+i)*addrLen:], digi.Repeated, last); err != nil {
+			return nil, err
+		}
+	}
+	buf = append(buf, f.Control)
+	if hasPID(f.Control) {
+		buf = append(buf, f.PID)
+	}
+	return append(buf, f.Info...), nil
+}
+
+// UnmarshalBinary decodes an AX.25 frame, as received from a KISS TNC,
+// into f.
+func (f *Frame) UnmarshalBinary(data []byte) error {
+	if len(data) < 2*addrLen+1 {
+		return ErrShortFrame
+	}
+	var addrs []Address
+	for i := 0; ; i++ {
+		if len(data) < addrLen+1 {
+			return ErrShortFrame
+		}
+		addr, bit7, last, err := decodeAddress(data[:addrLen])
+		if err != nil {
+			return err
+		}
+		if i >= 2 {
+			addr.Repeated = bit7
+		}
+		addrs = append(addrs, addr)
+		data = data[addrLen:]
+		if last {
+			break
+		}
+		if len(addrs) == 2+MaxDigipeaters {
+			return ErrTooManyDigis
+		}
+	}
+	if len(addrs) < 2 {
+		return ErrBadAddress
+	}
+
+	*f = Frame{
+		Dst:     addrs[0],
+		Src:     addrs[1],
+		Path:    addrs[2:],
+		Control: data[0],
+	}
+	if len(f.Path) == 0 {
+		f.Path = nil
+	}
+	data = data[1:]
+	if hasPID(f.Control) {
+		if len(data) == 0 {
+			return ErrMissingPID
+		}
+		f.PID = data[0]
+		data = data[1:]
+	}
+	f.Info = append([]byte(nil), data...)
+	return nil
+}
+
+// Decode decodes an AX.25 frame. It is shorthand for UnmarshalBinary.
+func Decode(data []byte) (*Frame, error) {
+	f := &Frame{}
+	if err := f.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// String formats the frame header the way monitor programs do, e.g.
+// "K1ABC-7>HAMIRC,WIDE1-1*".
+func (f *Frame) String() string {
+	var sb strings.Builder
+	sb.WriteString(f.Src.String())
+	sb.WriteByte('>')
+	sb.WriteString(f.Dst.String())
+	for _, digi := range f.Path {
+		sb.WriteByte(',')
+		sb.WriteString(digi.String())
+	}
+	return sb.String()
+}
@@ -0,0 +1,98 @@
+package ax25
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Address
+		err  error
+	}{
+		{in: "K1ABC", want: Address{Call: "K1ABC"}},
+		{in: "k1abc-7", want: Address{Call: "K1ABC", SSID: 7}},
+		{in: "WIDE2-1*", want: Address{Call: "WIDE2", SSID: 1, Repeated: true}},
+		{in: "K1ABC-16", err: ErrInvalidSSID},
+		{in: "K1ABC-", err: ErrInvalidSSID},
+		{in: "TOOLONG1", err: ErrInvalidCall},
+		{in: "K1/ABC", err: ErrInvalidCall},
+		{in: "", err: ErrInvalidCall},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAddress(tt.in)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ParseAddress(%q) error = %v, want %v", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseAddress(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAddress(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUIFrameEncoding(t *testing.T) {
+	frame := UI(Address{Call: "HAMIRC"}, Address{Call: "K1ABC", SSID: 7}, []byte("hi"))
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	want := []byte{
+		'H' << 1, 'A' << 1, 'M' << 1, 'I' << 1, 'R' << 1, 'C' << 1, 0xE0,
+		'K' << 1, '1' << 1, 'A' << 1, 'B' << 1, 'C' << 1, ' ' << 1, 0x6F,
+		ControlUI, PIDNoLayer3, 'h', 'i',
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("encoded frame = % X, want % X", data, want)
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	path, err := ParsePath("WIDE1-1*,WIDE2-1")
+	if err != nil {
+		t.Fatalf("ParsePath: %v", err)
+	}
+	frame := UI(Address{Call: "HAMIRC"}, Address{Call: "W1AW", SSID: 15}, []byte(":n!W1AW@x PRIVMSG #a :hello"))
+	frame.Path = path
+
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	got, err := Decode(data)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if got.String() != "W1AW-15>HAMIRC,WIDE1-1*,WIDE2-1" {
+		t.Fatalf("decoded header = %s", got)
+	}
+	if !got.IsUI() || got.PID != PIDNoLayer3 {
+		t.Fatalf("control/pid = %#x/%#x, want UI/%#x", got.Control, got.PID, PIDNoLayer3)
+	}
+	if !bytes.Equal(got.Info, frame.Info) {
+		t.Fatalf("info = %q, want %q", got.Info, frame.Info)
+	}
+}
+
+func TestDecodeRejectsBareText(t *testing.T) {
+	if _, err := Decode([]byte(":nick!K1ABC@name PRIVMSG #test :hello")); err == nil {
+		t.Fatal("Decode accepted a bare text frame")
+	}
+}
+
+func TestDecodeShortFrame(t *testing.T) {
+	if _, err := Decode([]byte{'K' << 1}); !errors.Is(err, ErrShortFrame) {
+		t.Fatalf("error = %v, want ErrShortFrame", err)
+	}
+}
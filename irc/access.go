@@ -0,0 +1,56 @@
+package irc
+
+import (
+	"slices"
+	"strings"
+)
+
+// txDenied returns why traffic from the local user may not be sent over
+// the radio, or "" if it may.
+func (s *Server) txDenied(user *User) string {
+	switch {
+	case s.ReceiveOnly:
+		return "this station is receive-only"
+	case user.rxonly:
+		return "your username is not a valid callsign"
+	case len(s.TransmitAllow) > 0 && !slices.Contains(s.TransmitAllow, baseCallsign(user.Callsign)):
+		return "your callsign is not permitted to transmit"
+	}
+	if reason := s.authDenied(user); reason != "" {
+		return reason
+	}
+	return ""
+}
+
+// canTransmit reports whether traffic from the local user may be sent
+// over the radio.
+func (s *Server) canTransmit(user *User) bool {
+	return s.txDenied(user) == ""
+}
+
+// SetTransmitAllow sets the callsigns permitted to transmit. SSIDs are
+// ignored, so allowing K1ABC also allows K1ABC-7. An empty list allows
+// everyone.
+func (s *Server) SetTransmitAllow(callsigns []string) {
+	s.TransmitAllow = baseCallsigns(callsigns)
+}
+
+// SetOpers sets the callsigns of the operators, who may manage bans once
+// logged in. SSIDs are ignored.
+func (s *Server) SetOpers(callsigns []string) {
+	s.Opers = baseCallsigns(callsigns)
+}
+
+// baseCallsigns returns the base callsigns in a list given on the
+// command line, skipping blank entries.
+func baseCallsigns(callsigns []string) []string {
+	var calls []string
+	for _, call := range callsigns {
+		call = strings.TrimSpace(call)
+		if call == "" {
+			continue
+		}
+		calls = append(calls, baseCallsign(call))
+	}
+	return calls
+}
@@ -0,0 +1,22 @@
+package irc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTransmitAllow(t *testing.T) {
+	s := NewServer()
+	s.SetTransmitAllow([]string{"K1ABC", " w1aw-7", ""})
+	if want := []string{"K1ABC", "W1AW"}; !slices.Equal(s.TransmitAllow, want) {
+		t.Fatalf("TransmitAllow = %q, want %q", s.TransmitAllow, want)
+	}
+	for call, want := range map[string]bool{"W1AW": true, "K1ABC-9": true, "W2XYZ": false} {
+		user := NewUser("ham", nil)
+		user.Callsign = call
+		user.local = true
+		if got := s.canTransmit(user); got != want {
+			t.Errorf("%s may transmit: %v, want %v", call, got, want)
+		}
+	}
+}
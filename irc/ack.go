@@ -0,0 +1,180 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAckRetries is how many times an unacknowledged private
+	// message is retransmitted before giving up.
+	DefaultAckRetries = 3
+
+	// DefaultAckTimeout is how long to wait for the first ACK. Each
+	// retransmission waits twice as long as the one before, plus up to
+	// half of DefaultAckTimeout of random jitter so that stations
+	// retrying at once do not keep colliding.
+	DefaultAckTimeout = 30 * time.Second
+
+	// ackTag carries the message ID on private messages that want an
+	// ACK.
+	ackTag = "a"
+
+	// ackSeenFor is how long a receiver remembers message IDs it has
+	// delivered, so retransmissions are ACKed again but shown once.
+	ackSeenFor = 30 * time.Minute
+
+	// ackRepeatGap is how long after ACKing a message a copy of it is
+	// ACKed again. Copies heard sooner come from digipeaters and other
+	// receive paths, not from retransmission.
+	ackRepeatGap = 10 * time.Second
+)
+
+// ackTracker tracks private messages waiting for an ACK, and the IDs of
+// messages already delivered to local users.
+type ackTracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingAck
+	seen    map[string]time.Time
+}
+
+type pendingAck struct {
+	sender *User
+	target string
+	// callsign is the target's; only it may acknowledge the message.
+	callsign string
+	lines    []string
+	tries    int
+	timer    *time.Timer
+}
+
+// newAckID returns a message ID for a new acknowledged message.
+func newAckID() string {
+	return fmt.Sprintf("%06x", rand.N(0x1000000))
+}
+
+// transmitAcked transmits a private message with an ACK request and
+// retransmits it until it is acknowledged or AckRetries is reached.
+func (s *Server) transmitAcked(sender *User, cmd, target, text string) {
+	id := newAckID()
+	lines := s.transmit(sender, wireTags{ackTag: id}, cmd, target, text)
+	if lines == nil {
+		return
+	}
+
+	p := &pendingAck{sender: sender, target: target, lines: lines}
+	if u := s.Nick(target); u != nil {
+		p.callsign = u.Callsign
+	}
+	s.acks.mu.Lock()
+	if s.acks.pending == nil {
+		s.acks.pending = make(map[string]*pendingAck)
+	}
+	s.acks.pending[id] = p
+	p.timer = time.AfterFunc(s.ackBackoff(0), func() { s.retransmit(id) })
+	s.acks.mu.Unlock()
+}
+
+func (s *Server) ackBackoff(tries int) time.Duration {
+	timeout := s.AckTimeout
+	if timeout <= 0 {
+		timeout = DefaultAckTimeout
+	}
+	return timeout<<tries + rand.N(timeout/2+1)
+}
+
+func (s *Server) retransmit(id string) {
+	s.acks.mu.Lock()
+	p, ok := s.acks.pending[id]
+	if !ok {
+		s.acks.mu.Unlock()
+		return
+	}
+	p.tries++
+	if p.tries > s.AckRetries {
+		delete(s.acks.pending, id)
+		s.acks.mu.Unlock()
+		log.Printf("No ACK from %s for message %s", p.target, id)
+		s.reply(p.sender, "NOTICE", p.sender.Nick, fmt.Sprintf("Message to %s not acknowledged; gave up after %d tries.", p.target, p.tries))
+		return
+	}
+	p.timer = time.AfterFunc(s.ackBackoff(p.tries), func() { s.retransmit(id) })
+	s.acks.mu.Unlock()
+
+	s.debugf("<TNC> retransmitting %s to %s, try %d", id, p.target, p.tries+1)
+	s.transmitLines(p.sender, p.lines)
+}
+
+// handleAckLine handles an ACK line heard over the radio. ACKs go
+// through the same callsign, ban and signature checks as messages, so
+// nobody can claim delivery on another station's behalf.
+func (s *Server) handleAckLine(tags wireTags, args []string, heard time.Time) {
+	if len(args) < 4 {
+		return
+	}
+	callsign := senderCallsign(args[0])
+	if ok, _ := s.checkRemoteCallsign(callsign); !ok {
+		return
+	}
+	if b := s.banned(args[0], callsign, args[2], args[3]); b != nil {
+		s.debugf("<TNC> dropping ACK from %s banned by %s", args[0], b.Mask)
+		s.stats.add("banned messages dropped", 1)
+		return
+	}
+	if len(s.Keyring) > 0 {
+		if status := s.checkSignature(tags, args, callsign, heard); status == sigForged || status == sigReplayed {
+			s.debugf("<TNC> dropping %s ACK from %s", status, args[0])
+			s.stats.add("bad ACKs dropped", 1)
+			return
+		}
+	}
+	s.handleAck(senderNick(args[0]), callsign, args[3])
+}
+
+// handleAck handles an ACK heard over the radio: from, sending as
+// callsign, acknowledges message id.
+func (s *Server) handleAck(from, callsign, id string) {
+	s.acks.mu.Lock()
+	p, ok := s.acks.pending[id]
+	if !ok || nickKey(p.target) != nickKey(from) || baseCallsign(p.callsign) != baseCallsign(callsign) {
+		s.acks.mu.Unlock()
+		return
+	}
+	p.timer.Stop()
+	delete(s.acks.pending, id)
+	s.acks.mu.Unlock()
+
+	s.reply(p.sender, "NOTICE", p.sender.Nick, fmt.Sprintf("Message to %s delivered.", p.target))
+}
+
+// ackReceived sends an ACK for message id, heard at heard, from sender
+// to the local user it was addressed to. It reports whether the message
+// was already delivered, in which case it should not be shown again.
+func (s *Server) ackReceived(recipient *User, sender, id string, heard time.Time) (seen bool) {
+	key := nickKey(sender) + "/" + id
+
+	s.acks.mu.Lock()
+	if s.acks.seen == nil {
+		s.acks.seen = make(map[string]time.Time)
+	}
+	for k, at := range s.acks.seen {
+		if heard.Sub(at) > ackSeenFor {
+			delete(s.acks.seen, k)
+		}
+	}
+	acked, seen := s.acks.seen[key]
+	again := !seen || heard.Sub(acked) >= ackRepeatGap
+	if again {
+		s.acks.seen[key] = heard
+	}
+	s.acks.mu.Unlock()
+
+	if again && s.canTransmit(recipient) {
+		tags := s.signTags(recipient, nil, "ACK", sender, id)
+		s.transmitLines(recipient, []string{radioLine(tags, recipient.ID(), "ACK", sender, id)})
+	}
+	return seen
+}
@@ -0,0 +1,41 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sparques/hamirc/kiss"
+)
+
+func TestRetransmitIsAckedAgain(t *testing.T) {
+	s := NewServer()
+	s.link = newTNCLink("test", 0, kiss.Params{}, nil)
+	conn, r := testClient(t, s, "NICK ham", "USER K1ABC 0 * :Ham")
+	expect(t, conn, r, RPL_WELCOME)
+
+	line := "@a=abc123 :far!W1AW@Hiram PRIVMSG ham :are you there?"
+	heard := time.Now()
+	s.handleRadioLine("W1AW", line, heard)
+	expect(t, conn, r, "PRIVMSG ham :are you there?")
+	// a digipeated copy, then a retransmission after our ACK was lost,
+	// both inside the dedup window
+	s.handleRadioLine("W1AW", line, heard.Add(time.Second))
+	s.handleRadioLine("W1AW", line, heard.Add(DefaultAckTimeout-time.Second))
+
+	var acks int
+	for {
+		f, _ := s.txq.pop(heard.Add(time.Hour))
+		if f == nil {
+			break
+		}
+		for _, l := range f.lines {
+			if strings.Contains(l, "ACK far :abc123") {
+				acks++
+			}
+		}
+	}
+	if acks != 2 {
+		t.Fatalf("sent %d ACKs, want 2", acks)
+	}
+}
@@ -0,0 +1,174 @@
+package irc
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDutyCycle is the share of the time the station may spend
+	// transmitting, and DefaultUserDutyCycle the share for each callsign.
+	DefaultDutyCycle     = 0.5
+	DefaultUserDutyCycle = 0.25
+
+	// DefaultAirtimeBurst is how much airtime may be used at once after
+	// a quiet spell.
+	DefaultAirtimeBurst = 30 * time.Second
+
+	// DefaultAirtimeMaxWait is the longest a message over budget is held
+	// back before it is refused instead.
+	DefaultAirtimeMaxWait = 2 * time.Minute
+
+	// assumedTXDelay stands in for the TNC's TXDELAY when hamirc leaves
+	// it alone and so does not know it.
+	assumedTXDelay = 300 * time.Millisecond
+
+	// frameOverhead is the bytes HDLC adds to each frame: the opening and
+	// closing flags and the FCS.
+	frameOverhead = 4
+)
+
+// airtimeBucket is a token bucket holding airtime. It fills at the duty
+// cycle rate up to the burst size, and may go negative as transmissions
+// are scheduled ahead.
+type airtimeBucket struct {
+	level time.Duration
+	last  time.Time
+}
+
+func (b *airtimeBucket) fill(now time.Time, rate float64, burst time.Duration) {
+	if b.last.IsZero() {
+		b.level = burst
+	} else if now.After(b.last) {
+		b.level = min(burst, b.level+time.Duration(float64(now.Sub(b.last))*rate))
+	}
+	b.last = now
+}
+
+// wait returns how long until the bucket holds cost.
+func (b *airtimeBucket) wait(cost time.Duration, rate float64) time.Duration {
+	if b.level >= cost {
+		return 0
+	}
+	if rate <= 0 {
+		return math.MaxInt64
+	}
+	return time.Duration(float64(cost-b.level) / rate)
+}
+
+// roundWait rounds a wait to whole seconds for telling users about it.
+func roundWait(wait time.Duration) time.Duration {
+	return max(wait.Round(time.Second), time.Second)
+}
+
+// airtimeBudget holds the station-wide bucket and one for each callsign.
+type airtimeBudget struct {
+	mu      sync.Mutex
+	station airtimeBucket
+	users   map[string]*airtimeBucket
+}
+
+// frameAirtime estimates how long the radio takes to send a frame of
+// data, keying up included.
+func (s *Server) frameAirtime(data []byte) time.Duration {
+	if s.Bitrate <= 0 {
+		return 0
+	}
+	keyup := assumedTXDelay
+	if s.TNCParams.TXDelay != nil {
+		keyup = *s.TNCParams.TXDelay
+	}
+	if s.TNCParams.TXTail != nil {
+		keyup += *s.TNCParams.TXTail
+	}
+	bits := (len(data) + frameOverhead) * 8
+	return keyup + time.Duration(bits)*time.Second/time.Duration(s.Bitrate)
+}
+
+func (s *Server) framesAirtime(frames [][]byte) time.Duration {
+	var total time.Duration
+	for _, data := range frames {
+		total += s.frameAirtime(data)
+	}
+	return total
+}
+
+// reserveAirtime charges frames to be sent for callsign against the
+// station and callsign budgets. It returns how long to hold them back
+// to stay within budget, or an error if that would be longer than
+// AirtimeMaxWait, in which case nothing is charged.
+func (s *Server) reserveAirtime(callsign string, frames [][]byte, now time.Time) (time.Duration, error) {
+	if s.Bitrate <= 0 {
+		return 0, nil
+	}
+	cost := s.framesAirtime(frames)
+
+	b := &s.airtime
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.users == nil {
+		b.users = make(map[string]*airtimeBucket)
+	}
+	user, ok := b.users[baseCallsign(callsign)]
+	if !ok {
+		user = &airtimeBucket{}
+		b.users[baseCallsign(callsign)] = user
+	}
+	b.station.fill(now, s.DutyCycle, s.AirtimeBurst)
+	user.fill(now, s.UserDutyCycle, s.AirtimeBurst)
+
+	wait := max(b.station.wait(cost, s.DutyCycle), user.wait(cost, s.UserDutyCycle))
+	if wait > s.AirtimeMaxWait {
+		return wait, fmt.Errorf("over the airtime budget; try again in about %s", roundWait(wait))
+	}
+	b.station.level -= cost
+	user.level -= cost
+	return wait, nil
+}
+
+// chargeAirtime charges frames that must go out regardless, such as
+// station identification, to the station budget.
+func (s *Server) chargeAirtime(frames [][]byte, now time.Time) {
+	if s.Bitrate <= 0 {
+		return
+	}
+	cost := s.framesAirtime(frames)
+	b := &s.airtime
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.station.fill(now, s.DutyCycle, s.AirtimeBurst)
+	b.station.level -= cost
+}
+
+// mergedAirtime is the airtime saved when a line from callsign joins
+// another frame instead of going in one of its own: the keying up and
+// frame headers, less the line separator.
+func (s *Server) mergedAirtime(callsign string) time.Duration {
+	if s.Bitrate <= 0 {
+		return 0
+	}
+	data, err := s.encodeFrame(callsign, "")
+	if err != nil {
+		return 0
+	}
+	return s.frameAirtime(data) - time.Duration(len(lineSeparator)*8)*time.Second/time.Duration(s.Bitrate)
+}
+
+// refundAirtime gives back airtime charged to callsign that turned out
+// not to be needed.
+func (s *Server) refundAirtime(callsign string, cost time.Duration, now time.Time) {
+	if s.Bitrate <= 0 || cost <= 0 {
+		return
+	}
+	b := &s.airtime
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.station.fill(now, s.DutyCycle, s.AirtimeBurst)
+	b.station.level = min(s.AirtimeBurst, b.station.level+cost)
+	if user, ok := b.users[baseCallsign(callsign)]; ok {
+		user.fill(now, s.UserDutyCycle, s.AirtimeBurst)
+		user.level = min(s.AirtimeBurst, user.level+cost)
+	}
+}
@@ -0,0 +1,80 @@
+package irc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sparques/hamirc/kiss"
+)
+
+func TestAirtimeBudget(t *testing.T) {
+	s := NewServer()
+	s.Bitrate = 1200
+	s.AirtimeBurst = 3 * time.Second
+	s.AirtimeMaxWait = 10 * time.Second
+	frame := bytes.Repeat([]byte("x"), 100)
+	if got := s.frameAirtime(frame); got < 990*time.Millisecond || got > time.Second {
+		t.Fatalf("airtime of 100 bytes at 1200 baud = %s, want about 993ms", got)
+	}
+
+	now := time.Now()
+	frames := func(n int) [][]byte {
+		f := make([][]byte, n)
+		for i := range f {
+			f[i] = frame
+		}
+		return f
+	}
+	if wait, err := s.reserveAirtime("K1ABC-7", frames(3), now); wait != 0 || err != nil {
+		t.Fatalf("first burst: wait %s, %v", wait, err)
+	}
+	// the callsign's budget refills at a quarter of real time
+	if wait, err := s.reserveAirtime("K1ABC", frames(1), now); wait < 3*time.Second || wait > 5*time.Second || err != nil {
+		t.Fatalf("over budget: wait %s, %v; want about 4s", wait, err)
+	}
+	if _, err := s.reserveAirtime("K1ABC", frames(3), now); err == nil {
+		t.Fatal("a message due after -maxtxwait was not refused")
+	}
+	// another callsign has its own budget, but shares the station's
+	if wait, err := s.reserveAirtime("W1AW", frames(1), now); wait <= 0 || err != nil {
+		t.Fatalf("station over budget: wait %s, %v", wait, err)
+	}
+	if wait, _ := s.reserveAirtime("W1AW", frames(1), now.Add(time.Minute)); wait != 0 {
+		t.Fatalf("after a quiet minute: wait %s", wait)
+	}
+}
+
+func TestMergedAirtime(t *testing.T) {
+	s := NewServer()
+	s.link = newTNCLink("test", 0, kiss.Params{}, nil)
+	s.Bitrate = 1200
+	s.MTU = 256
+	s.CoalesceWindow = time.Second
+	user := NewUser("ham", io.Discard)
+	user.Callsign = "K1ABC"
+
+	one := radioLine(nil, user.ID(), "PRIVMSG", "#net", "one")
+	two := radioLine(nil, user.ID(), "PRIVMSG", "#net", "two")
+	for _, line := range []string{one, two} {
+		if !s.transmitLines(user, []string{line}) {
+			t.Fatal("not transmitted")
+		}
+	}
+	merged, err := s.encodeFrame(user.Callsign, one+lineSeparator+two)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both lines share a frame, so only that frame is charged
+	want := s.AirtimeBurst - s.frameAirtime(merged)
+	s.airtime.mu.Lock()
+	station, callsign := s.airtime.station.level, s.airtime.users["K1ABC"].level
+	s.airtime.mu.Unlock()
+	for _, level := range []time.Duration{station, callsign} {
+		if level < want || level > want+50*time.Millisecond {
+			t.Errorf("airtime left %s, want %s", level, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// passwordRounds is how many times passwords are hashed. It only has
+// to make guessing from a stolen credentials file slow.
+const passwordRounds = 100_000
+
+// Credentials maps base callsigns to password hashes made by
+// HashPassword.
+type Credentials map[string]string
+
+// LoadCredentials reads a credentials file. Each line holds a callsign
+// and a password hash made by HashPassword, separated by whitespace.
+// Blank lines and lines starting with # are ignored.
+func LoadCredentials(path string) (Credentials, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	creds := make(Credentials)
+	scanner := bufio.NewScanner(fh)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a callsign and a password hash", path, lineNo)
+		}
+		creds[baseCallsign(fields[0])] = fields[1]
+	}
+	return creds, scanner.Err()
+}
+
+// HashPassword returns a salted hash of password for use in a
+// credentials file.
+func HashPassword(password string) string {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	return fmt.Sprintf("sha256$%d$%x$%x", passwordRounds, salt, hashPassword(salt, password, passwordRounds))
+}
+
+func hashPassword(salt []byte, password string, rounds int) []byte {
+	sum := sha256.Sum256(append(salt, password...))
+	for range rounds - 1 {
+		sum = sha256.Sum256(sum[:])
+	}
+	return sum[:]
+}
+
+// Check reports whether password is correct for callsign. SSIDs are
+// ignored.
+func (c Credentials) Check(callsign, password string) bool {
+	stored, ok := c[baseCallsign(callsign)]
+	if !ok {
+		return false
+	}
+	fields := strings.Split(stored, "$")
+	if len(fields) != 4 || fields[0] != "sha256" {
+		return false
+	}
+	rounds, err := strconv.Atoi(fields[1])
+	if err != nil || rounds < 1 {
+		return false
+	}
+	salt, err1 := hex.DecodeString(fields[2])
+	want, err2 := hex.DecodeString(fields[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hashPassword(salt, password, rounds), want) == 1
+}
+
+// Has reports whether callsign has credentials.
+func (c Credentials) Has(callsign string) bool {
+	_, ok := c[baseCallsign(callsign)]
+	return ok
+}
+
+// AuthPolicy decides what happens to local users who do not
+// authenticate, with PASS or SASL, as the callsign they register with.
+// Whatever the policy, a callsign listed in Credentials only transmits
+// for users authenticated as it.
+type AuthPolicy int
+
+const (
+	// AuthNone does not require authentication.
+	AuthNone AuthPolicy = iota
+	// AuthReceiveOnly lets unauthenticated users in, receive-only.
+	AuthReceiveOnly
+	// AuthRefuse refuses unauthenticated users.
+	AuthRefuse
+)
+
+var authPolicyNames = map[string]AuthPolicy{
+	"none":   AuthNone,
+	"rxonly": AuthReceiveOnly,
+	"refuse": AuthRefuse,
+}
+
+// ParseAuthPolicy parses one of "none", "rxonly" or "refuse".
+func ParseAuthPolicy(s string) (AuthPolicy, error) {
+	policy, ok := authPolicyNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown auth policy %q: use none, rxonly, or refuse", s)
+	}
+	return policy, nil
+}
+
+// authenticated reports whether the local user authenticated as the
+// callsign they registered with.
+func (u *User) authenticated() bool {
+	return u.account != "" && u.account == baseCallsign(u.Callsign)
+}
+
+// authDenied returns why the local user may not transmit for want of
+// authentication, or "".
+func (s *Server) authDenied(user *User) string {
+	switch {
+	case user.authenticated():
+		return ""
+	case s.Credentials.Has(user.Callsign):
+		return fmt.Sprintf("you have not logged in as %s", baseCallsign(user.Callsign))
+	case s.AuthPolicy != AuthNone:
+		return "you have not logged in"
+	}
+	return ""
+}
+
+// checkAuth applies the PASS given before registration and
+// AuthPolicy to a user completing registration. It returns false, after
+// telling the client why, if the user must be disconnected.
+func (s *Server) checkAuth(user *User) bool {
+	if user.password != "" {
+		password := user.password
+		user.password = ""
+		if !s.Credentials.Check(user.Callsign, password) {
+			log.Printf("Bad password for %s.", user.Callsign)
+			s.reply(user, ERR_PASSWDMISMATCH, replyNick(user), "Password incorrect")
+			fmt.Fprintf(user, "ERROR :Closing link: bad password\r\n")
+			return false
+		}
+		user.account = baseCallsign(user.Callsign)
+	}
+	if user.account != "" && !user.authenticated() {
+		s.reply(user, ERR_INVALIDUSERNAME, replyNick(user), user.Callsign, fmt.Sprintf("You are logged in as %s; your username must match", user.account))
+		fmt.Fprintf(user, "ERROR :Closing link: username does not match account\r\n")
+		return false
+	}
+	if !user.authenticated() && (s.Bouncer || s.AuthPolicy == AuthRefuse) {
+		s.reply(user, ERR_PASSWDMISMATCH, replyNick(user), "Authentication required")
+		fmt.Fprintf(user, "ERROR :Closing link: authentication required\r\n")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package irc
+
+import "fmt"
+
+// setAway marks a local user away with message, or back if message is
+// empty. Local users sharing a channel with them are told, which only
+// reaches clients that negotiated away-notify.
+func (s *Server) setAway(user *User, message string) {
+	s.Lock()
+	user.away = message
+	var recipients []*User
+	for _, ch := range s.Channels {
+		if ch.Users[nickKey(user.Nick)] != user {
+			continue
+		}
+		for _, u := range ch.Users {
+			if u != user && u.Local() {
+				recipients = append(recipients, u)
+			}
+		}
+	}
+	s.Unlock()
+
+	line := fmt.Sprintf(":%s AWAY\r\n", user.ID())
+	if message == "" {
+		s.reply(user, RPL_UNAWAY, user.Nick, "You are no longer marked as being away")
+	} else {
+		line = fmt.Sprintf(":%s AWAY :%s\r\n", user.ID(), message)
+		s.reply(user, RPL_NOWAWAY, user.Nick, "You have been marked as being away")
+	}
+	for _, recipient := range uniqueUsers(recipients) {
+		fmt.Fprint(recipient, line)
+	}
+}
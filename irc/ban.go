@@ -0,0 +1,224 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ban drops radio traffic that matches it before it reaches local
+// users, or creates users and channels. Bans are kept with the rest of
+// the server state.
+type Ban struct {
+	// Mask is "call:CALLSIGN", matching the callsign with any SSID
+	// unless one is given; "re:EXPR", a regular expression matched
+	// against the message text; or a nick!user@host mask with * and ?
+	// wildcards.
+	Mask string
+	// Channel limits the ban to messages to one channel.
+	Channel string `json:",omitempty"`
+	Reason  string `json:",omitempty"`
+	SetBy   string
+	SetAt   time.Time
+	// Drops counts the messages dropped by the ban.
+	Drops uint64
+
+	re *regexp.Regexp
+}
+
+const (
+	banCall  = "call:"
+	banRegex = "re:"
+)
+
+// newBan returns a ban on mask. A bare nick is taken to mean nick!*@*.
+func newBan(mask, channel string) (*Ban, error) {
+	b := &Ban{Mask: mask, Channel: channel}
+	switch {
+	case strings.HasPrefix(mask, banCall):
+		call := strings.ToUpper(strings.TrimPrefix(mask, banCall))
+		if !ValidCallsign(call) {
+			return nil, fmt.Errorf("%q is not a callsign", call)
+		}
+		b.Mask = banCall + call
+	case !strings.HasPrefix(mask, banRegex) && !strings.ContainsAny(mask, "!@"):
+		b.Mask = mask + "!*@*"
+	}
+	if err := b.compile(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// compile prepares the ban for matching.
+func (b *Ban) compile() error {
+	var err error
+	switch {
+	case strings.HasPrefix(b.Mask, banCall):
+	case strings.HasPrefix(b.Mask, banRegex):
+		b.re, err = regexp.Compile(strings.TrimPrefix(b.Mask, banRegex))
+	default:
+		expr := regexp.QuoteMeta(b.Mask)
+		expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+		b.re, err = regexp.Compile("(?i)^" + expr + "$")
+	}
+	return err
+}
+
+// matches reports whether a message from prefix, sent by callsign to
+// target, falls under the ban.
+func (b *Ban) matches(prefix, callsign, target, text string) bool {
+	if b.Channel != "" && channelKey(b.Channel) != channelKey(target) {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(b.Mask, banCall):
+		call := strings.TrimPrefix(b.Mask, banCall)
+		if strings.Contains(call, "-") {
+			return strings.EqualFold(call, callsign)
+		}
+		return call == baseCallsign(callsign)
+	case strings.HasPrefix(b.Mask, banRegex):
+		return b.re.MatchString(text)
+	}
+	return b.re.MatchString(prefix)
+}
+
+// scope returns the channel the ban applies to, or "*" for the whole
+// server.
+func (b *Ban) scope() string {
+	if b.Channel == "" {
+		return "*"
+	}
+	return b.Channel
+}
+
+// banned returns the first ban a message heard over the radio falls
+// under, counting the drop against it, or nil.
+func (s *Server) banned(prefix, callsign, target, text string) *Ban {
+	s.Lock()
+	defer s.Unlock()
+	for _, b := range s.Bans {
+		if b.matches(prefix, callsign, target, text) {
+			b.Drops++
+			return b
+		}
+	}
+	return nil
+}
+
+// addBan adds a ban. It returns false if the same ban is already set.
+func (s *Server) addBan(b *Ban) bool {
+	s.Lock()
+	defer s.Unlock()
+	for _, existing := range s.Bans {
+		if existing.Mask == b.Mask && channelKey(existing.Channel) == channelKey(b.Channel) {
+			return false
+		}
+	}
+	s.Bans = append(s.Bans, b)
+	return true
+}
+
+// removeBan removes a ban. It returns false if there was no such ban.
+func (s *Server) removeBan(b *Ban) bool {
+	s.Lock()
+	defer s.Unlock()
+	n := len(s.Bans)
+	s.Bans = slices.DeleteFunc(s.Bans, func(existing *Ban) bool {
+		return existing.Mask == b.Mask && channelKey(existing.Channel) == channelKey(b.Channel)
+	})
+	return len(s.Bans) < n
+}
+
+// bans returns copies of the bans on channel, or of every ban if
+// channel is "".
+func (s *Server) bans(channel string) []Ban {
+	s.Lock()
+	defer s.Unlock()
+	var bans []Ban
+	for _, b := range s.Bans {
+		if channel == "" || channelKey(b.Channel) == channelKey(channel) {
+			bans = append(bans, *b)
+		}
+	}
+	return bans
+}
+
+// listBans sends user the bans on channel as RPL_BANLIST lines.
+func (s *Server) listBans(user *User, channel string) {
+	for _, b := range s.bans(channel) {
+		s.reply(user, RPL_BANLIST, user.Nick, channel, b.Mask, b.SetBy, fmt.Sprint(b.SetAt.Unix()))
+	}
+	s.reply(user, RPL_ENDOFBANLIST, user.Nick, channel, "End of channel ban list")
+}
+
+// listBanStats sends user every ban with its drop count, for STATS b.
+func (s *Server) listBanStats(user *User) {
+	for _, b := range s.bans("") {
+		line := fmt.Sprintf("%s %s set by %s: %d dropped", b.scope(), b.Mask, b.SetBy, b.Drops)
+		if b.Reason != "" {
+			line += " (" + b.Reason + ")"
+		}
+		s.reply(user, RPL_STATSDEBUG, user.Nick, line)
+	}
+}
+
+// banMode handles MODE channel +b, -b or b. With no masks it lists the
+// channel's bans; otherwise an oper adds or removes them.
+func (s *Server) banMode(user *User, channel, modes string, masks []string) {
+	if len(masks) == 0 {
+		s.listBans(user, channel)
+		return
+	}
+	if !s.isOper(user) {
+		s.reply(user, ERR_CHANOPRIVSNEEDED, user.Nick, channel, "You're not a server operator")
+		return
+	}
+	remove := strings.HasPrefix(modes, "-")
+	change := "+b"
+	if remove {
+		change = "-b"
+	}
+	for _, mask := range masks {
+		if mask, ok := s.setBan(user, remove, mask, channel, ""); ok {
+			fmt.Fprintf(user, ":%s MODE %s %s %s\r\n", user.ID(), channel, change, mask)
+		}
+	}
+}
+
+// isOper reports whether user may manage bans: a local user logged in
+// as one of the Opers callsigns.
+func (s *Server) isOper(user *User) bool {
+	return user.Local() && user.authenticated() && slices.Contains(s.Opers, baseCallsign(user.Callsign))
+}
+
+// setBan adds (or, if remove is set, removes) a ban for user, an oper,
+// telling them if it cannot. It returns the ban's mask as stored.
+func (s *Server) setBan(user *User, remove bool, mask, channel, reason string) (string, bool) {
+	b, err := newBan(mask, channel)
+	if err != nil {
+		s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("Bad ban %s: %s", mask, err))
+		return "", false
+	}
+	if remove {
+		if !s.removeBan(b) {
+			s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("%s is not banned", b.Mask))
+			return "", false
+		}
+		log.Printf("%s removed the ban on %s in %s", user.ID(), b.Mask, b.scope())
+		return b.Mask, true
+	}
+	b.Reason = reason
+	b.SetBy = user.Nick
+	b.SetAt = time.Now()
+	if !s.addBan(b) {
+		s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("%s is already banned", b.Mask))
+		return "", false
+	}
+	log.Printf("%s banned %s in %s", user.ID(), b.Mask, b.scope())
+	return b.Mask, true
+}
@@ -0,0 +1,63 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBanMatch(t *testing.T) {
+	tests := []struct {
+		mask, channel string
+		prefix, call  string
+		target, text  string
+		want          bool
+	}{
+		{"call:k1abc", "", "ham!K1ABC-7@x", "K1ABC-7", "#net", "hi", true},
+		{"call:K1ABC-7", "", "ham!K1ABC-9@x", "K1ABC-9", "#net", "hi", false},
+		{"call:K1ABC", "#other", "ham!K1ABC@x", "K1ABC", "#net", "hi", false},
+		{"Ham", "", "ham!K1ABC@x", "K1ABC", "#net", "hi", true},
+		{"*!W1AW*@*", "", "ham!K1ABC@x", "K1ABC", "#net", "hi", false},
+		{"re:(?i)buy now", "#net", "ham!K1ABC@x", "K1ABC", "#NET", "BUY NOW", true},
+	}
+	for _, test := range tests {
+		b, err := newBan(test.mask, test.channel)
+		if err != nil {
+			t.Fatalf("newBan(%q): %s", test.mask, err)
+		}
+		if got := b.matches(test.prefix, test.call, test.target, test.text); got != test.want {
+			t.Errorf("ban %q on %q matches %s to %s = %t", b.Mask, test.channel, test.prefix, test.target, got)
+		}
+	}
+	if _, err := newBan("call:not a call", ""); err == nil {
+		t.Error("banned an invalid callsign")
+	}
+}
+
+func TestBanCommand(t *testing.T) {
+	s := NewServer()
+	s.Credentials = Credentials{"K1ABC": HashPassword("hunter2")}
+	s.SetOpers([]string{"K1ABC"})
+
+	conn, r := testClient(t, s, "NICK other", "USER W1AW 0 * :Hiram", "BAN call:N0CALL")
+	expect(t, conn, r, ERR_NOPRIVILEGES)
+
+	conn, r = testClient(t, s, "PASS hunter2", "NICK op", "USER K1ABC 0 * :Ham",
+		"BAN call:N0CALL spam", "MODE #net +b far", "MODE #net b")
+	expect(t, conn, r, "NOTICE op :Banned call:N0CALL")
+	expect(t, conn, r, "MODE #net +b far!*@*")
+	expect(t, conn, r, RPL_BANLIST+" op #net far!*@* op ")
+	expect(t, conn, r, RPL_ENDOFBANLIST)
+
+	s.handleRadioLine("", ":spammer!N0CALL@x PRIVMSG #new :hi", time.Now())
+	s.handleRadioLine("", ":far!W2XYZ@x PRIVMSG #net :hi", time.Now())
+	if s.Nick("spammer") != nil || s.Nick("far") != nil {
+		t.Error("banned stations were added to the server")
+	}
+	s.Lock()
+	_, ok := s.Channels["#new"]
+	drops := s.Bans[0].Drops
+	s.Unlock()
+	if ok || drops != 1 {
+		t.Errorf("after a banned message: channel created %t, drops %d", ok, drops)
+	}
+}
@@ -0,0 +1,69 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// DefaultBacklogLines is how many lines a detached bouncer session
+// keeps for its next client.
+const DefaultBacklogLines = 500
+
+// session returns the bouncer session for callsign, if there is one.
+func (s *Server) session(callsign string) *User {
+	s.Lock()
+	defer s.Unlock()
+	return s.sessions[baseCallsign(callsign)]
+}
+
+// reattach moves the client of the newly registered tmp onto an
+// existing session: the client is brought up to date with the
+// session's nick and channels, then gets the backlog.
+func (s *Server) reattach(tmp, session *User) {
+	s.Lock()
+	type chanState struct {
+		name, topic string
+		names       []string
+	}
+	var channels []chanState
+	for _, ch := range s.Channels {
+		if ch.Users[nickKey(session.Nick)] != session {
+			continue
+		}
+		state := chanState{name: ch.Name, topic: ch.Topic}
+		for _, u := range ch.Users {
+			state.names = append(state.names, u.Nick)
+		}
+		channels = append(channels, state)
+	}
+	s.Unlock()
+	slices.SortFunc(channels, func(a, b chanState) int {
+		return strings.Compare(channelKey(a.name), channelKey(b.name))
+	})
+
+	if tmp.Nick != session.Nick {
+		fmt.Fprintf(tmp, ":%s NICK :%s\r\n", tmp.ID(), session.Nick)
+		tmp.Nick = session.Nick
+	}
+	s.welcome(tmp)
+	sessionID := session.ID()
+	for _, ch := range channels {
+		fmt.Fprintf(tmp, ":%s JOIN :%s\r\n", sessionID, ch.name)
+		s.channelInfo(tmp, ch.name, ch.topic, ch.names)
+	}
+
+	tmp.mu.Lock()
+	clients := slices.Clone(tmp.clients)
+	tmp.mu.Unlock()
+	for _, c := range clients {
+		tmp.detach(c)
+		replayed := session.attach(c)
+		log.Printf("Reattached %s to session %s, replaying %d lines.", c.remoteAddr(), sessionID, replayed)
+		if replayed > 0 {
+			// only the client that got the backlog needs telling
+			fmt.Fprintf(c, ":%s NOTICE %s :Replayed %d lines received while detached.\r\n", s.Name, session.Nick, replayed)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package irc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialsCheck(t *testing.T) {
+	creds := Credentials{"K1ABC": HashPassword("hunter2")}
+	if !creds.Check("k1abc-7", "hunter2") {
+		t.Error("Check rejected the right password")
+	}
+	if creds.Check("K1ABC", "hunter3") {
+		t.Error("Check accepted the wrong password")
+	}
+	if creds.Check("W1AW", "hunter2") {
+		t.Error("Check accepted an unknown callsign")
+	}
+}
+
+// testClient connects a client to s over a pipe and registers it.
+func testClient(t *testing.T, s *Server, lines ...string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, serverConn := net.Pipe()
+	go s.handleConnection(serverConn)
+	go func() {
+		for _, line := range lines {
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}()
+	return conn, bufio.NewReader(conn)
+}
+
+// expect reads lines until one contains want.
+func expect(t *testing.T, conn net.Conn, r *bufio.Reader, want string) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for %q: %s", want, err)
+		}
+		if strings.Contains(line, want) {
+			return
+		}
+	}
+}
+
+func TestBouncerSession(t *testing.T) {
+	s := NewServer()
+	s.Bouncer = true
+	s.Credentials = Credentials{"K1ABC": HashPassword("hunter2")}
+
+	bad, badr := testClient(t, s, "PASS wrong", "NICK ham", "USER K1ABC 0 * :Ham")
+	expect(t, bad, badr, ERR_PASSWDMISMATCH)
+	bad.Close()
+
+	conn, r := testClient(t, s, "PASS hunter2", "NICK ham", "USER K1ABC 0 * :Ham", "JOIN #net")
+	expect(t, conn, r, RPL_ENDOFNAMES)
+	conn.Close()
+
+	session := s.session("K1ABC")
+	if session == nil {
+		t.Fatal("no session after registering")
+	}
+	for session.attached() {
+		time.Sleep(time.Millisecond)
+	}
+	fmt.Fprintf(session, ":W1AW!W1AW@Hiram PRIVMSG #net :heard while away\r\n")
+
+	phone, pr := testClient(t, s, "PASS hunter2", "NICK other", "USER K1ABC-7 0 * :Ham")
+	expect(t, phone, pr, "NICK :ham")
+	expect(t, phone, pr, "JOIN :#net")
+	expect(t, phone, pr, "heard while away")
+	expect(t, phone, pr, "Replayed 1 lines")
+
+	desktop, dr := testClient(t, s, "PASS hunter2", "NICK ham", "USER K1ABC 0 * :Ham")
+	expect(t, desktop, dr, RPL_ENDOFNAMES)
+	for clients := 0; clients < 2; time.Sleep(time.Millisecond) {
+		session.mu.Lock()
+		clients = len(session.clients)
+		session.mu.Unlock()
+	}
+	// net.Pipe is unbuffered, so write while the test reads
+	go fmt.Fprintf(session, ":W1AW!W1AW@Hiram PRIVMSG #net :to both\r\n")
+	expect(t, phone, pr, "to both")
+	expect(t, desktop, dr, "to both")
+}
@@ -0,0 +1,81 @@
+package irc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// callsignRE matches the ITU structure of amateur callsigns: a one or
+// two character prefix (letters, or a letter and a digit in either
+// order), a digit, and a suffix of up to four characters ending in a
+// letter. An AX.25 style -SSID may follow.
+var callsignRE = regexp.MustCompile(`^(?:[A-Z]{1,2}|[A-Z][0-9]|[0-9][A-Z]{1,2})[0-9][A-Z0-9]{0,3}[A-Z](?:-(?:1[0-5]|[0-9]))?$`)
+
+// ValidCallsign reports whether s is a plausible amateur callsign, with
+// an optional -SSID, such as "K1ABC", "2E0XYZ" or "VE3ABC-7".
+func ValidCallsign(s string) bool {
+	return callsignRE.MatchString(strings.ToUpper(s))
+}
+
+// baseCallsign returns s uppercased and without any -SSID.
+func baseCallsign(s string) string {
+	call, _, _ := strings.Cut(strings.ToUpper(s), "-")
+	return call
+}
+
+// CallsignPolicy decides what happens to a station whose callsign is
+// not plausible.
+type CallsignPolicy int
+
+const (
+	// CallsignAllow does not check callsigns.
+	CallsignAllow CallsignPolicy = iota
+	// CallsignFlag accepts remote traffic but marks it in the message
+	// text. For local users it is the same as CallsignReceiveOnly.
+	CallsignFlag
+	// CallsignReceiveOnly lets local users register but never transmit.
+	CallsignReceiveOnly
+	// CallsignRefuse refuses local registration and drops remote
+	// traffic.
+	CallsignRefuse
+)
+
+var callsignPolicyNames = map[string]CallsignPolicy{
+	"allow":  CallsignAllow,
+	"flag":   CallsignFlag,
+	"rxonly": CallsignReceiveOnly,
+	"refuse": CallsignRefuse,
+}
+
+// ParseCallsignPolicy parses one of "allow", "flag", "rxonly" or
+// "refuse".
+func ParseCallsignPolicy(s string) (CallsignPolicy, error) {
+	policy, ok := callsignPolicyNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown callsign policy %q: use allow, flag, rxonly, or refuse", s)
+	}
+	return policy, nil
+}
+
+// badCallsignMark is put in front of remote messages from stations with
+// implausible callsigns under CallsignFlag.
+const badCallsignMark = "[bad callsign] "
+
+// checkRemoteCallsign applies RemoteCallsigns to a station heard over
+// the radio. It returns false if its traffic should be dropped, and
+// flag if its messages should be marked.
+func (s *Server) checkRemoteCallsign(callsign string) (ok, flag bool) {
+	if ValidCallsign(callsign) {
+		return true, false
+	}
+	switch s.RemoteCallsigns {
+	case CallsignAllow:
+		return true, false
+	case CallsignRefuse:
+		s.debugf("<TNC> dropping traffic from invalid callsign %q", callsign)
+		return false, false
+	default:
+		return true, true
+	}
+}
@@ -0,0 +1,42 @@
+package irc
+
+import "testing"
+
+func TestValidCallsign(t *testing.T) {
+	valid := []string{"K1ABC", "w1aw", "KD2XYZ", "VE3ABC-7", "2E0ABC", "9A1AA", "JA1ABC-15", "E21ABC", "4X4AAA"}
+	for _, call := range valid {
+		if !ValidCallsign(call) {
+			t.Errorf("ValidCallsign(%q) = false, want true", call)
+		}
+	}
+
+	invalid := []string{"", "sparques", "K1ABC-16", "K1ABC-", "KABC", "K1", "K1ABC/P", "K1ABCDE", "123456", "user"}
+	for _, call := range invalid {
+		if ValidCallsign(call) {
+			t.Errorf("ValidCallsign(%q) = true, want false", call)
+		}
+	}
+}
+
+func TestParseCallsignPolicy(t *testing.T) {
+	policy, err := ParseCallsignPolicy("RXOnly")
+	if err != nil || policy != CallsignReceiveOnly {
+		t.Fatalf("ParseCallsignPolicy(RXOnly) = %v, %v", policy, err)
+	}
+	if _, err := ParseCallsignPolicy("maybe"); err == nil {
+		t.Fatal("ParseCallsignPolicy accepted an unknown policy")
+	}
+}
+
+func TestLocalCallsignPolicy(t *testing.T) {
+	s := NewServer()
+	conn, r := testClient(t, s, "NICK guest", "USER sparques 0 * :Guest")
+	expect(t, conn, r, RPL_WELCOME)
+	if s.Nick("guest").rxonly {
+		t.Fatal("default policy made a user receive-only")
+	}
+
+	s.LocalCallsigns = CallsignReceiveOnly
+	conn, r = testClient(t, s, "NICK visitor", "USER sparques 0 * :Visitor")
+	expect(t, conn, r, "receive-only (your username is not a valid callsign)")
+}
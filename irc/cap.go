@@ -0,0 +1,150 @@
+package irc
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// supportedCaps are the IRCv3 capabilities hamirc offers in CAP LS.
+// hamirc has no channel modes, so names never carry more than one
+// prefix and multi-prefix needs nothing beyond being acknowledged.
+var supportedCaps = []string{"away-notify", "batch", "echo-message", "message-tags", "multi-prefix", "server-time"}
+
+// tagCaps maps tags to the capability a client needs to see them. Other
+// tags need message-tags.
+var tagCaps = map[string]string{
+	timeTag: "server-time",
+	"batch": "batch",
+}
+
+// caps returns the capabilities offered to clients.
+func (s *Server) caps() []string {
+	caps := slices.Clone(supportedCaps)
+	if s.History != nil {
+		caps = append(caps, "draft/chathistory")
+	}
+	if len(s.saslMechs()) > 0 {
+		caps = append(caps, "sasl")
+	}
+	return caps
+}
+
+// timeTag is the server-time tag. Messages heard over the radio carry
+// the time the frame was received.
+const timeTag = "time"
+
+func serverTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// capCmd handles CAP LS, LIST, REQ and END for client c.
+func (s *Server) capCmd(c *client, args []string) (quit bool) {
+	user := c.user
+	nick := user.Nick
+	if !user.registered {
+		nick = "*"
+	}
+	if len(args) < 2 {
+		s.reply(user, ERR_NEEDMOREPARAMS, nick, "CAP", "Not enough parameters")
+		return
+	}
+
+	switch sub := strings.ToUpper(args[1]); sub {
+	case "LS":
+		c.mu.Lock()
+		c.negotiating = !user.registered
+		c.mu.Unlock()
+		s.reply(user, "CAP", nick, "LS", strings.Join(s.caps(), " "))
+	case "LIST":
+		c.mu.Lock()
+		var enabled []string
+		for _, name := range s.caps() {
+			if c.caps[name] {
+				enabled = append(enabled, name)
+			}
+		}
+		c.mu.Unlock()
+		s.reply(user, "CAP", nick, "LIST", strings.Join(enabled, " "))
+	case "REQ":
+		if len(args) < 3 {
+			s.reply(user, ERR_NEEDMOREPARAMS, nick, "CAP", "Not enough parameters")
+			return
+		}
+		requested := strings.Fields(args[2])
+		// a request is granted or refused as a whole
+		for _, name := range requested {
+			if !slices.Contains(s.caps(), strings.TrimPrefix(name, "-")) {
+				s.reply(user, "CAP", nick, "NAK", args[2])
+				return
+			}
+		}
+		c.mu.Lock()
+		c.negotiating = !user.registered
+		if c.caps == nil {
+			c.caps = make(map[string]bool)
+		}
+		for _, name := range requested {
+			if off, ok := strings.CutPrefix(name, "-"); ok {
+				delete(c.caps, off)
+			} else {
+				c.caps[name] = true
+			}
+		}
+		c.mu.Unlock()
+		s.reply(user, "CAP", nick, "ACK", args[2])
+	case "END":
+		c.mu.Lock()
+		c.negotiating = false
+		c.mu.Unlock()
+		if user.Callsign != "" {
+			return s.acceptUser(user)
+		}
+	default:
+		s.reply(user, ERR_INVALIDCAPCMD, nick, args[1], "Invalid CAP subcommand")
+	}
+	return
+}
+
+// filterLine returns line as a client with caps should see it: tags it
+// did not negotiate are removed, and nil is returned if it should not
+// see the line at all.
+func filterLine(line []byte, caps map[string]bool) []byte {
+	switch lineCommand(line) {
+	case "AWAY":
+		if !caps["away-notify"] {
+			return nil
+		}
+	case "BATCH":
+		if !caps["batch"] {
+			return nil
+		}
+	}
+	if len(line) == 0 || line[0] != '@' {
+		return line
+	}
+	tags, rest := splitTags(string(line))
+	for key := range tags {
+		need, ok := tagCaps[key]
+		if !ok {
+			need = "message-tags"
+		}
+		if !caps[need] {
+			delete(tags, key)
+		}
+	}
+	return []byte(tags.String() + rest)
+}
+
+// lineCommand returns the command of an IRC line.
+func lineCommand(line []byte) string {
+	rest := string(line)
+	if strings.HasPrefix(rest, "@") {
+		_, rest, _ = strings.Cut(rest, " ")
+	}
+	if strings.HasPrefix(rest, ":") {
+		_, rest, _ = strings.Cut(rest, " ")
+	}
+	cmd, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	return cmd
+}
@@ -0,0 +1,59 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterLine(t *testing.T) {
+	line := "@a=1;time=2024-01-02T03:04:05.000Z :n!K1ABC@x PRIVMSG #net :hi\r\n"
+	tests := []struct {
+		caps map[string]bool
+		want string
+	}{
+		{nil, ":n!K1ABC@x PRIVMSG #net :hi\r\n"},
+		{map[string]bool{"server-time": true}, "@time=2024-01-02T03:04:05.000Z :n!K1ABC@x PRIVMSG #net :hi\r\n"},
+		{map[string]bool{"message-tags": true}, "@a=1 :n!K1ABC@x PRIVMSG #net :hi\r\n"},
+		{map[string]bool{"server-time": true, "message-tags": true}, line},
+	}
+	for _, test := range tests {
+		if got := string(filterLine([]byte(line), test.caps)); got != test.want {
+			t.Errorf("filterLine with %v = %q, want %q", test.caps, got, test.want)
+		}
+	}
+
+	away := []byte(":n!K1ABC@x AWAY :lunch\r\n")
+	if got := filterLine(away, nil); got != nil {
+		t.Errorf("AWAY reached a client without away-notify: %q", got)
+	}
+	if got := filterLine(away, map[string]bool{"away-notify": true}); string(got) != string(away) {
+		t.Errorf("filterLine(AWAY) with away-notify = %q", got)
+	}
+}
+
+func TestCapNegotiation(t *testing.T) {
+	s := NewServer()
+	conn, r := testClient(t, s, "CAP LS 302", "NICK ham", "USER K1ABC 0 * :Ham", "CAP REQ :server-time bogus", "CAP REQ :server-time", "CAP END", "JOIN #net")
+	expect(t, conn, r, "CAP * LS :away-notify batch echo-message message-tags multi-prefix server-time")
+
+	// nothing is welcomed until CAP END
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for _, want := range []string{"CAP * NAK :server-time bogus", "CAP * ACK :server-time", RPL_WELCOME} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for %q: %s", want, err)
+		}
+		if !strings.Contains(line, want) {
+			t.Fatalf("got %q, want %q", line, want)
+		}
+	}
+	expect(t, conn, r, "@time=")
+}
+
+func TestEchoMessage(t *testing.T) {
+	// no TNC, so nothing is transmitted, but the message was still sent
+	s := NewServer()
+	conn, r := testClient(t, s, "CAP REQ :echo-message", "CAP END", "NICK ham", "USER K1ABC 0 * :Ham", "JOIN #net", "PRIVMSG #net :hello")
+	expect(t, conn, r, ":ham!K1ABC@Ham PRIVMSG #net :hello")
+}
@@ -16,6 +16,10 @@ type Channel struct {
 	Topic       string
 	TopicTime   time.Time
 	TopicWho    string
+	// LastSeen is when the channel was created or last had a message or
+	// topic change. It drives ChannelExpiry. Channels loaded from state
+	// saved without it count as seen when loaded.
+	LastSeen time.Time
 }
 
 func NewChannel(name string) *Channel {
@@ -23,6 +27,8 @@ func NewChannel(name string) *Channel {
 		Mutex: &sync.Mutex{},
 		Name:  name,
 		Users: make(ChanUserMap),
+
+		LastSeen: time.Now(),
 	}
 }
 
@@ -0,0 +1,207 @@
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSendQ is how many lines may wait to be written to a client
+	// before it is disconnected for falling behind. It leaves room for a
+	// full bouncer backlog replay.
+	DefaultSendQ = 2048
+
+	// clientWriteTimeout is how long a single write to a client may
+	// block.
+	clientWriteTimeout = 30 * time.Second
+
+	// clientDrainTimeout is how long a closing client gets to take the
+	// lines still queued for it, such as a final ERROR.
+	clientDrainTimeout = 5 * time.Second
+)
+
+var errSendQExceeded = errors.New("SendQ exceeded")
+
+// client is one IRC connection. A local user normally has exactly one;
+// a bouncer session may have several, or none while it is detached.
+//
+// Lines for the client are queued and written by the client's own
+// goroutine, so writing to a user never blocks on the network.
+type client struct {
+	conn net.Conn
+	// user is the user the connection is attached to. It only changes
+	// when a new connection attaches to an existing bouncer session.
+	user *User
+
+	queue chan []byte
+	quit  chan struct{} // closed to have the writer drain the queue and stop
+	done  chan struct{} // closed when the writer has stopped
+
+	mu sync.Mutex
+	// caps holds the IRCv3 capabilities the client negotiated.
+	// negotiating is set from CAP LS or REQ until CAP END, and holds
+	// off registration.
+	caps        map[string]bool
+	negotiating bool
+	closing     bool
+
+	// lastActive is when the client last sent a line, lastPing when it
+	// was last sent a PING, and pingSent when the oldest unanswered PING
+	// was sent. lag is the time taken to answer the last PING answered.
+	lastActive time.Time
+	lastPing   time.Time
+	pingSent   time.Time
+	lag        time.Duration
+	measured   bool
+	// quitReason is set when the server drops the client.
+	quitReason string
+
+	// saslMech is the SASL mechanism in progress, and saslBuf the
+	// response received so far.
+	saslMech string
+	saslBuf  []byte
+}
+
+// newClient starts a client for conn that queues up to sendq lines.
+func newClient(conn net.Conn, sendq int) *client {
+	if sendq <= 0 {
+		sendq = DefaultSendQ
+	}
+	now := time.Now()
+	c := &client{
+		conn:       conn,
+		queue:      make(chan []byte, sendq),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+		lastActive: now,
+		lastPing:   now,
+	}
+	go c.writer()
+	return c
+}
+
+// writeLine queues a line for the client, first dropping whatever the
+// client did not negotiate. If the queue is full the client is too far
+// behind and is disconnected.
+func (c *client) writeLine(line []byte) error {
+	c.mu.Lock()
+	line = filterLine(line, c.caps)
+	closing := c.closing
+	c.mu.Unlock()
+	if line == nil || closing {
+		return nil
+	}
+	select {
+	case c.queue <- slices.Clone(line):
+		return nil
+	default:
+		c.mu.Lock()
+		if c.quitReason == "" {
+			c.quitReason = errSendQExceeded.Error()
+		}
+		c.mu.Unlock()
+		c.kill(errSendQExceeded)
+		return errSendQExceeded
+	}
+}
+
+// writer writes queued lines to the connection, flushing whenever the
+// queue runs dry.
+func (c *client) writer() {
+	defer close(c.done)
+	w := bufio.NewWriter(c.conn)
+	write := func(line []byte) bool {
+		c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+		if _, err := w.Write(line); err != nil {
+			c.kill(err)
+			return false
+		}
+		if len(c.queue) == 0 {
+			if err := w.Flush(); err != nil {
+				c.kill(err)
+				return false
+			}
+		}
+		return true
+	}
+	for {
+		select {
+		case line := <-c.queue:
+			if !write(line) {
+				return
+			}
+		case <-c.quit:
+			for {
+				select {
+				case line := <-c.queue:
+					if !write(line) {
+						return
+					}
+				default:
+					w.Flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// close writes out what is queued for the client, then closes the
+// connection.
+func (c *client) close() {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return
+	}
+	c.closing = true
+	c.mu.Unlock()
+
+	close(c.quit)
+	select {
+	case <-c.done:
+	case <-time.After(clientDrainTimeout):
+	}
+	c.conn.Close()
+}
+
+// kill closes the connection at once, dropping anything queued. The
+// connection's reader then sees the error and cleans up.
+func (c *client) kill(err error) {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return
+	}
+	c.closing = true
+	c.mu.Unlock()
+
+	log.Printf("Dropping client %s: %s", c.remoteAddr(), err)
+	c.conn.Close()
+}
+
+// Write writes p, which must hold whole lines, to the client. It lets
+// replies meant for one connection of a bouncer session be written with
+// fmt.Fprintf.
+func (c *client) Write(p []byte) (int, error) {
+	return len(p), c.writeLine(p)
+}
+
+// hasCap reports whether the client negotiated capability name.
+func (c *client) hasCap(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caps[name]
+}
+
+func (c *client) remoteAddr() string {
+	if c.conn == nil {
+		return "<unknown>"
+	}
+	return c.conn.RemoteAddr().String()
+}
@@ -0,0 +1,50 @@
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientSendQ(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	c := newClient(conn, 4)
+
+	// nobody reads from peer, so the writer stalls and the queue fills
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = c.writeLine([]byte(fmt.Sprintf("line %d\r\n", i)))
+	}
+	if !errors.Is(err, errSendQExceeded) {
+		t.Fatalf("writing to a stalled client: %v, want %v", err, errSendQExceeded)
+	}
+	select {
+	case <-c.done:
+	case <-time.After(time.Second):
+		t.Fatal("writer still running after the client was dropped")
+	}
+}
+
+func TestClientCloseDrains(t *testing.T) {
+	conn, peer := net.Pipe()
+	c := newClient(conn, 0)
+	for i := range 3 {
+		c.writeLine([]byte(fmt.Sprintf("line %d\r\n", i)))
+	}
+	go c.close()
+
+	r := bufio.NewReader(peer)
+	for i := range 3 {
+		line, err := r.ReadString('\n')
+		if want := fmt.Sprintf("line %d\r\n", i); err != nil || line != want {
+			t.Fatalf("read %q, %v; want %q", line, err, want)
+		}
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("connection still open after close")
+	}
+}
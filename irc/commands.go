@@ -1,13 +1,15 @@
 package irc
 
 import (
+	"fmt"
 	"strings"
 )
 
 type serverCommand func(s *Server, user *User, args []string) (quit bool)
 
 var cmdSet = map[string]serverCommand{
-	"CAP":      capabilities,
+	"AWAY":     away,
+	"BAN":      ban,
 	"ECHO":     echo,
 	"JOIN":     join,
 	"LIST":     list,
@@ -16,20 +18,34 @@ var cmdSet = map[string]serverCommand{
 	"NICK":     nick,
 	"NOTICE":   notice,
 	"PART":     part,
+	"PASS":     pass,
 	"PING":     ping,
 	"PONG":     pong,
 	"PRIVMSG":  privmsg,
 	"TOPIC":    topic,
+	"UNBAN":    unban,
 	"USER":     user,
 	"USERHOST": userhost,
 	"QUIT":     quit,
+	"SILENCE":  silence,
+	"STATS":    statsCmd,
+	"TAGMSG":   tagmsg,
 	"WHO":      who,
 	"WHOIS":    whois,
 }
 
-func capabilities(s *Server, user *User, args []string) (quit bool) {
-	// We don't support much...
-	s.reply(user, "CAP", "*", "LS", "")
+func away(s *Server, user *User, args []string) (quit bool) {
+	message := ""
+	if len(args) > 1 {
+		message = args[1]
+	}
+	s.setAway(user, message)
+	return
+}
+
+func tagmsg(s *Server, user *User, args []string) (quit bool) {
+	// TAGMSG only carries client-only tags, such as typing
+	// notifications; they are not worth the airtime, so drop them.
 	return
 }
 
@@ -47,11 +63,33 @@ func nick(s *Server, user *User, args []string) (quit bool) {
 		s.reply(user, ERR_NONICKNAMEGIVEN, user.Nick, "No nickname given")
 		return
 	}
-	oldNick := user.Nick
+	if !validNick(args[1]) {
+		s.reply(user, ERR_ERRONEUSNICKNAME, replyNick(user), args[1], "Erroneous nickname")
+		return
+	}
+	if !user.registered {
+		// in use nicks are caught when registration completes, where a
+		// bouncer session may turn out to own them
+		user.Nick = args[1]
+		if user.Callsign != "" {
+			return s.acceptUser(user)
+		}
+		return
+	}
 	s.changeNick(user, args[1])
-	if oldNick == "" && user.Callsign != "" {
-		s.acceptUser(user)
+	return
+}
+
+func pass(s *Server, user *User, args []string) (quit bool) {
+	if user.registered {
+		s.reply(user, ERR_ALREADYREGISTERED, replyNick(user), "You may not reregister")
+		return
+	}
+	if len(args) < 2 {
+		s.reply(user, ERR_NEEDMOREPARAMS, replyNick(user), "PASS", "Not enough parameters")
+		return
 	}
+	user.password = args[1]
 	return
 }
 
@@ -65,10 +103,27 @@ func user(s *Server, user *User, args []string) (quit bool) {
 		s.reply(user, ERR_NEEDMOREPARAMS, replyNick(user), "USER", "Need more params")
 		return
 	}
+	if s.LocalCallsigns != CallsignAllow && !ValidCallsign(args[1]) {
+		if s.LocalCallsigns == CallsignRefuse {
+			s.reply(user, ERR_INVALIDUSERNAME, replyNick(user), args[1], "Your username must be your callsign")
+			return
+		}
+		user.rxonly = true
+	}
+	if user.cert != "" {
+		// a client certificate decides who the user is
+		if baseCallsign(args[1]) != user.cert {
+			s.reply(user, ERR_INVALIDUSERNAME, replyNick(user), args[1], fmt.Sprintf("Your certificate is for %s; your username must match", user.cert))
+			return
+		}
+		if user.account == "" {
+			user.account = user.cert
+		}
+	}
 	user.Callsign = args[1]
 	user.RealName = args[4]
 	if user.Nick != "" {
-		s.acceptUser(user)
+		return s.acceptUser(user)
 	}
 	return
 }
@@ -177,6 +232,10 @@ func mode(s *Server, user *User, args []string) (quit bool) {
 		s.reply(user, RPL_CHANNELMODEIS, user.Nick, args[1], "+")
 		return
 	}
+	if strings.HasPrefix(args[1], "#") && strings.TrimLeft(args[2], "+-") == "b" {
+		s.banMode(user, args[1], args[2], args[3:])
+		return
+	}
 	mode := args[1]
 	if len(args) > 2 {
 		mode = args[2]
@@ -226,7 +285,69 @@ func part(s *Server, user *User, args []string) (quit bool) {
 	return
 }
 
+// ban handles BAN [#channel] <mask> [reason]; with no arguments it
+// lists the bans.
+func ban(s *Server, user *User, args []string) (quit bool) {
+	if len(args) == 1 {
+		s.listStats(user, "b")
+		return
+	}
+	channel, mask, reason, ok := banArgs(args[1:])
+	switch {
+	case !s.isOper(user):
+		s.reply(user, ERR_NOPRIVILEGES, user.Nick, "Permission Denied- You're not an IRC operator")
+	case !ok:
+		s.reply(user, ERR_NEEDMOREPARAMS, user.Nick, "BAN", "Not enough parameters")
+	default:
+		if mask, ok := s.setBan(user, false, mask, channel, reason); ok {
+			s.reply(user, "NOTICE", user.Nick, strings.TrimSpace("Banned "+mask+" "+channel))
+		}
+	}
+	return
+}
+
+// unban handles UNBAN [#channel] <mask>.
+func unban(s *Server, user *User, args []string) (quit bool) {
+	channel, mask, _, ok := banArgs(args[1:])
+	switch {
+	case !s.isOper(user):
+		s.reply(user, ERR_NOPRIVILEGES, user.Nick, "Permission Denied- You're not an IRC operator")
+	case !ok:
+		s.reply(user, ERR_NEEDMOREPARAMS, user.Nick, "UNBAN", "Not enough parameters")
+	default:
+		if mask, ok := s.setBan(user, true, mask, channel, ""); ok {
+			s.reply(user, "NOTICE", user.Nick, strings.TrimSpace("Unbanned "+mask+" "+channel))
+		}
+	}
+	return
+}
+
+// banArgs splits the arguments to BAN or UNBAN into an optional
+// channel, the mask, and the reason.
+func banArgs(args []string) (channel, mask, reason string, ok bool) {
+	if len(args) > 0 && strings.HasPrefix(args[0], "#") {
+		channel, args = args[0], args[1:]
+	}
+	if len(args) == 0 {
+		return "", "", "", false
+	}
+	return channel, args[0], strings.Join(args[1:], " "), true
+}
+
+func statsCmd(s *Server, user *User, args []string) (quit bool) {
+	query := "*"
+	if len(args) > 1 {
+		query = args[1]
+	}
+	s.listStats(user, query)
+	return
+}
+
 func quit(s *Server, user *User, args []string) (quit bool) {
+	if user.session {
+		// the session stays on the air; only this client goes away
+		return true
+	}
 	if len(args) == 1 {
 		s.quit(user, "Client disconnected.")
 	} else {
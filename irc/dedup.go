@@ -0,0 +1,44 @@
+package irc
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultDedupWindow is how long a received line is remembered so that
+// copies of it, from digipeaters or other receive paths, are dropped.
+const DefaultDedupWindow = 30 * time.Second
+
+// dedupCache remembers recently heard radio lines. Lines carry the
+// sender's prefix, so identical text from different stations is not a
+// duplicate.
+type dedupCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// duplicate reports whether line was already heard within window, and
+// records it as heard at now.
+func (d *dedupCache) duplicate(line string, now time.Time, window time.Duration) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+	if now.Sub(d.lastSweep) > window {
+		for key, heard := range d.seen {
+			if now.Sub(heard) > window {
+				delete(d.seen, key)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	heard, ok := d.seen[line]
+	if ok && now.Sub(heard) <= window {
+		return true
+	}
+	d.seen[line] = now
+	return false
+}
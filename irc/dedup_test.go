@@ -0,0 +1,25 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	var d dedupCache
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	line := ":n!K1ABC@x PRIVMSG #a :hello"
+
+	if d.duplicate(line, now, 30*time.Second) {
+		t.Fatal("first copy reported as duplicate")
+	}
+	if !d.duplicate(line, now.Add(5*time.Second), 30*time.Second) {
+		t.Fatal("digipeated copy not reported as duplicate")
+	}
+	if d.duplicate(":m!W1AW@y PRIVMSG #a :hello", now.Add(5*time.Second), 30*time.Second) {
+		t.Fatal("same text from another station reported as duplicate")
+	}
+	if d.duplicate(line, now.Add(time.Minute), 30*time.Second) {
+		t.Fatal("line repeated after the window reported as duplicate")
+	}
+}
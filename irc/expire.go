@@ -0,0 +1,93 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultUserExpiry is how long a remote user may go unheard before
+	// they are parted from their channels and forgotten. Expiry is off
+	// unless asked for, so upgrading does not start forgetting users.
+	DefaultUserExpiry time.Duration = 0
+
+	// DefaultChannelExpiry is how long a channel may go without messages
+	// before it drops out of LIST. It is off by default too.
+	DefaultChannelExpiry time.Duration = 0
+
+	expireTick = time.Hour
+)
+
+// expire forgets stale remote users and idle channels for as long as
+// the server runs.
+func (s *Server) expire() {
+	for now := range time.Tick(expireTick) {
+		s.expireStale(now)
+	}
+}
+
+// expireStale parts remote users not heard for UserExpiry from their
+// channels and removes them, then removes channels idle for
+// ChannelExpiry that nobody is left in.
+func (s *Server) expireStale(now time.Time) {
+	type part struct {
+		line       string
+		recipients []*User
+	}
+	var (
+		stale []*User
+		parts []part
+	)
+
+	s.Lock()
+	if s.UserExpiry > 0 {
+		for _, u := range s.Users {
+			if !u.Local() && now.Sub(u.LastSeen) >= s.UserExpiry {
+				stale = append(stale, u)
+			}
+		}
+	}
+	for _, u := range stale {
+		log.Printf("Expiring %s, last heard %s", u.ID(), u.LastSeen.Format(time.DateOnly))
+		reason := fmt.Sprintf("Not heard for %d days", int(now.Sub(u.LastSeen).Hours()/24))
+		tags := wireTags{timeTag: serverTime(now)}
+		for _, ch := range s.Channels {
+			if ch.Users[nickKey(u.Nick)] != u {
+				continue
+			}
+			p := part{line: fmt.Sprintf("%s:%s PART %s :%s\r\n", tags, u.ID(), ch.Name, reason)}
+			for _, member := range ch.Users {
+				if member.Local() {
+					p.recipients = append(p.recipients, member)
+				}
+			}
+			parts = append(parts, p)
+		}
+	}
+	s.Unlock()
+
+	for _, p := range parts {
+		for _, recipient := range p.recipients {
+			fmt.Fprint(recipient, p.line)
+		}
+	}
+	for _, u := range stale {
+		s.removeUser(u)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for key, ch := range s.Channels {
+		if len(ch.Users) == 0 && s.channelIdle(ch, now) {
+			log.Printf("Expired channel %s", ch.Name)
+			delete(s.Channels, key)
+		}
+	}
+}
+
+// channelIdle reports whether ch has gone ChannelExpiry without
+// messages. The caller holds the server lock.
+func (s *Server) channelIdle(ch *Channel, now time.Time) bool {
+	return s.ChannelExpiry > 0 && now.Sub(ch.LastSeen) >= s.ChannelExpiry
+}
@@ -0,0 +1,49 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpireStale(t *testing.T) {
+	s := NewServer()
+	s.UserExpiry = 30 * 24 * time.Hour
+	s.ChannelExpiry = 30 * 24 * time.Hour
+	conn, r := testClient(t, s, "NICK local", "USER K1ABC 0 * :Ham", "JOIN #net")
+	expect(t, conn, r, RPL_ENDOFNAMES)
+	s.handleRadioLine("", ":far!W1AW@Hiram PRIVMSG #net :hello", time.Now())
+	expect(t, conn, r, "PRIVMSG #net :hello")
+	s.Channel("#old")
+
+	now := time.Now().Add(31 * 24 * time.Hour)
+	s.Lock()
+	s.Channels[channelKey("#net")].LastSeen = now
+	s.Unlock()
+	s.expireStale(now)
+	expect(t, conn, r, ":far!W1AW@Hiram PART #net :Not heard for 31 days")
+	if s.Nick("far") != nil || s.Channel("#net").Nick("far") != nil {
+		t.Fatal("far not forgotten")
+	}
+	if s.Nick("local") == nil {
+		t.Fatal("local user expired")
+	}
+	s.Lock()
+	_, old := s.Channels[channelKey("#old")]
+	s.Unlock()
+	if old {
+		t.Fatal("idle empty channel not forgotten")
+	}
+
+	// #net has a local user, so it stays, but drops out of LIST once idle
+	s.Lock()
+	s.Channels[channelKey("#net")].LastSeen = time.Now().Add(-31 * 24 * time.Hour)
+	s.Unlock()
+	fmt.Fprintf(conn, "LIST\r\n")
+	expect(t, conn, r, RPL_LISTSTART)
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.Contains(line, RPL_LISTEND) {
+		t.Fatalf("LIST gave %q, want only %s", line, RPL_LISTEND)
+	}
+}
@@ -0,0 +1,217 @@
+package irc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMTU is the largest information field hamirc puts in a
+	// frame, the AX.25 default N1 of 256 bytes.
+	DefaultMTU = 256
+
+	// DefaultFragmentTimeout is how long to wait for the rest of a
+	// fragmented message before delivering what arrived.
+	DefaultFragmentTimeout = 30 * time.Second
+
+	// maxFragments bounds how many fragments one message may be split
+	// into, and so how much a receiver will buffer for it.
+	maxFragments = 99
+
+	// minFragmentText is the least text a fragment carries, however
+	// small the MTU.
+	minFragmentText = 16
+
+	// incompleteMark is put in front of messages delivered with
+	// fragments missing; missingMark stands in for each gap.
+	incompleteMark = "[incomplete] "
+	missingMark    = " [...] "
+)
+
+// fragmentTag is the tag carrying "<id>/<n>/<total>" on each fragment,
+// counting n from 1.
+const fragmentTag = "f"
+
+// fragment splits a radio line into lines no longer than mtu bytes by
+// spreading text across numbered fragments. If the line already fits it
+// is returned as is.
+func fragment(tags wireTags, prefix, cmd, target, text string, mtu int, id string) []string {
+	line := radioLine(tags, prefix, cmd, target, text)
+	if mtu <= 0 || len(line) <= mtu {
+		return []string{line}
+	}
+
+	// room left for text once the largest possible fragment tag is added
+	fragTags := make(wireTags, len(tags)+1)
+	for k, v := range tags {
+		fragTags[k] = v
+	}
+	fragTags[fragmentTag] = fmt.Sprintf("%s/%d/%d", id, maxFragments, maxFragments)
+	room := max(mtu-len(radioLine(fragTags, prefix, cmd, target, "")), minFragmentText)
+
+	chunks := splitText(text, room)
+	if len(chunks) > maxFragments {
+		chunks = chunks[:maxFragments]
+	}
+	lines := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		fragTags[fragmentTag] = fmt.Sprintf("%s/%d/%d", id, i+1, len(chunks))
+		lines[i] = radioLine(fragTags, prefix, cmd, target, chunk)
+	}
+	return lines
+}
+
+// splitText cuts text into chunks of at most room bytes, preferring to
+// cut before a space and never cutting a UTF-8 sequence. Joining the
+// chunks gives back text. Receivers trim the end of each line, so no
+// chunk but the last ends in a space: cuts go before a whole run of
+// spaces, which then starts the next chunk's trailing parameter, where
+// it is kept.
+func splitText(text string, room int) []string {
+	var chunks []string
+	for len(text) > room {
+		cut := room
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		hard := cut
+		if space := strings.LastIndexByte(text[:cut], ' '); space > cut/2 {
+			cut = space
+		}
+		for cut > 0 && text[cut-1] == ' ' {
+			cut--
+		}
+		if cut == 0 {
+			// spaces fill the room, and some will be lost; or a single
+			// rune is wider than room, and is sent anyway
+			cut = hard
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	return append(chunks, text)
+}
+
+// parseFragmentTag parses a fragment tag value.
+func parseFragmentTag(value string) (id string, n, total int, ok bool) {
+	fields := strings.Split(value, "/")
+	if len(fields) != 3 || fields[0] == "" {
+		return "", 0, 0, false
+	}
+	n, err1 := strconv.Atoi(fields[1])
+	total, err2 := strconv.Atoi(fields[2])
+	if err1 != nil || err2 != nil || total < 1 || total > maxFragments || n < 1 || n > total {
+		return "", 0, 0, false
+	}
+	return fields[0], n, total, true
+}
+
+// reassembler collects the fragments of messages heard over the radio.
+type reassembler struct {
+	mu      sync.Mutex
+	partial map[string]*partialMessage
+}
+
+type partialMessage struct {
+	args  []string // the first fragment heard, minus its text
+	tags  wireTags
+	parts []string
+	have  []bool
+	got   int
+	timer *time.Timer
+	heard time.Time // when the latest fragment was heard
+}
+
+// add records a fragment heard at heard. When the message is complete,
+// deliver is called with the whole message; if the rest does not arrive
+// within timeout, deliver is called with what did arrive, marked
+// incomplete. Either way heard is when the last fragment arrived.
+func (r *reassembler) add(tags wireTags, args []string, heard time.Time, timeout time.Duration, deliver func(tags wireTags, args []string, heard time.Time)) {
+	id, n, total, ok := parseFragmentTag(tags[fragmentTag])
+	if !ok || len(args) < 4 {
+		return
+	}
+	key := nickKey(senderNick(args[0])) + "/" + id
+
+	r.mu.Lock()
+	if r.partial == nil {
+		r.partial = make(map[string]*partialMessage)
+	}
+	msg, ok := r.partial[key]
+	if !ok || len(msg.parts) != total {
+		if ok {
+			msg.timer.Stop()
+		}
+		msg = &partialMessage{
+			args:  args[:3:3],
+			tags:  tags,
+			parts: make([]string, total),
+			have:  make([]bool, total),
+		}
+		msg.timer = time.AfterFunc(timeout, func() {
+			r.mu.Lock()
+			if r.partial[key] != msg {
+				r.mu.Unlock()
+				return
+			}
+			delete(r.partial, key)
+			args := msg.assemble()
+			r.mu.Unlock()
+			deliver(msg.tags, args, msg.heard)
+		})
+		r.partial[key] = msg
+	}
+	msg.heard = heard
+	if !msg.have[n-1] {
+		msg.have[n-1] = true
+		msg.parts[n-1] = args[3]
+		msg.got++
+	}
+	if msg.got < total {
+		r.mu.Unlock()
+		return
+	}
+	msg.timer.Stop()
+	delete(r.partial, key)
+	whole := msg.assemble()
+	r.mu.Unlock()
+
+	deliver(msg.tags, whole, heard)
+}
+
+// assemble joins the fragments that arrived into a single message.
+func (msg *partialMessage) assemble() []string {
+	var sb strings.Builder
+	if msg.got < len(msg.parts) {
+		sb.WriteString(incompleteMark)
+	}
+	for i, part := range msg.parts {
+		if !msg.have[i] {
+			sb.WriteString(missingMark)
+			continue
+		}
+		sb.WriteString(part)
+	}
+	return append(msg.args, sb.String())
+}
+
+// senderNick returns the nick from a nick!user@host prefix.
+func senderNick(prefix string) string {
+	nick, _, _ := strings.Cut(prefix, "!")
+	return nick
+}
+
+// senderCallsign returns the callsign, the user part, from a
+// nick!user@host prefix.
+func senderCallsign(prefix string) string {
+	_, rest, _ := strings.Cut(prefix, "!")
+	call, _, _ := strings.Cut(rest, "@")
+	return call
+}
@@ -0,0 +1,100 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFragmentRoundTrip(t *testing.T) {
+	text := strings.TrimSpace(strings.Repeat("the quick brown fox jumps over the lazy dog 👍 ", 12))
+	lines := fragment(nil, "nick!K1ABC@Real_Name", "PRIVMSG", "#test", text, 128, "beef")
+	if len(lines) < 2 {
+		t.Fatalf("message was not fragmented: %d lines", len(lines))
+	}
+
+	got := make(chan []string, 1)
+	var r reassembler
+	// deliver out of order
+	for i := len(lines) - 1; i >= 0; i-- {
+		if len(lines[i]) > 128 {
+			t.Fatalf("fragment %d is %d bytes, over the MTU", i, len(lines[i]))
+		}
+		tags, rest := splitTags(lines[i])
+		r.add(tags, parse(rest), time.Now(), time.Minute, func(_ wireTags, args []string, _ time.Time) { got <- args })
+	}
+
+	args := <-got
+	if args[0] != "nick!K1ABC@Real_Name" || args[1] != "PRIVMSG" || args[2] != "#test" {
+		t.Fatalf("reassembled header = %q", args[:3])
+	}
+	if args[3] != text {
+		t.Fatalf("reassembled text = %q, want %q", args[3], text)
+	}
+}
+
+func TestFragmentKeepsSpaces(t *testing.T) {
+	text := strings.Repeat("a  b c   d ", 30) + "end"
+	// every MTU in the range puts fragment boundaries on spaces
+	for mtu := 60; mtu < 80; mtu++ {
+		lines := fragment(nil, "nick!K1ABC@Real_Name", "PRIVMSG", "#test", text, mtu, "beef")
+		got := make(chan []string, 1)
+		var r reassembler
+		for i, line := range lines {
+			if i < len(lines)-1 && strings.HasSuffix(line, " ") {
+				t.Fatalf("MTU %d: fragment %d ends in a space: %q", mtu, i, line)
+			}
+			tags, rest := splitTags(line)
+			r.add(tags, parse(rest), time.Now(), time.Minute, func(_ wireTags, args []string, _ time.Time) { got <- args })
+		}
+		if args := <-got; args[3] != text {
+			t.Fatalf("MTU %d: reassembled text = %q, want %q", mtu, args[3], text)
+		}
+	}
+}
+
+func TestFragmentShortLineUntouched(t *testing.T) {
+	lines := fragment(nil, "nick!K1ABC@x", "PRIVMSG", "#test", "hi", DefaultMTU, "beef")
+	if len(lines) != 1 || lines[0] != ":nick!K1ABC@x PRIVMSG #test :hi" {
+		t.Fatalf("lines = %q", lines)
+	}
+}
+
+func TestFragmentTimeoutMarksIncomplete(t *testing.T) {
+	text := strings.Repeat("0123456789", 30)
+	lines := fragment(nil, "nick!K1ABC@x", "PRIVMSG", "#test", text, 100, "cafe")
+	if len(lines) < 3 {
+		t.Fatalf("want at least 3 fragments, got %d", len(lines))
+	}
+
+	got := make(chan []string, 1)
+	var r reassembler
+	for _, line := range lines[:len(lines)-1] {
+		tags, rest := splitTags(line)
+		r.add(tags, parse(rest), time.Now(), 10*time.Millisecond, func(_ wireTags, args []string, _ time.Time) { got <- args })
+	}
+
+	select {
+	case args := <-got:
+		if !strings.HasPrefix(args[3], incompleteMark) || !strings.HasSuffix(args[3], missingMark) {
+			t.Fatalf("partial message = %q", args[3])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial message was never delivered")
+	}
+}
+
+func TestWireTags(t *testing.T) {
+	tags := wireTags{"f": "ab/1/2", "x": "semi;colon and space"}
+	line := radioLine(tags, "n!K1ABC@x", "PRIVMSG", "#a", "hi")
+	if !strings.HasPrefix(line, `@f=ab/1/2;x=semi\:colon\sand\sspace :n!K1ABC@x`) {
+		t.Fatalf("line = %q", line)
+	}
+	got, rest := splitTags(line)
+	if got["f"] != "ab/1/2" || got["x"] != "semi;colon and space" {
+		t.Fatalf("tags = %v", got)
+	}
+	if rest != ":n!K1ABC@x PRIVMSG #a :hi" {
+		t.Fatalf("rest = %q", rest)
+	}
+}
@@ -0,0 +1,329 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistoryLines is how many messages are kept for each channel
+	// and each private conversation.
+	DefaultHistoryLines = 1000
+
+	// historyMaxLimit is the most messages a CHATHISTORY request may ask
+	// for, as advertised in RPL_ISUPPORT.
+	historyMaxLimit = 100
+)
+
+// History is an on-disk message store with one JSON lines log per
+// channel and per pair of stations talking privately. Each log is kept to
+// about Lines messages; the oldest are dropped first.
+type History struct {
+	Dir   string
+	Lines int
+
+	mu    sync.Mutex
+	count map[string]int // lines in each log, once known
+}
+
+// historyEntry is one stored message.
+type historyEntry struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"` // nick!user@host of the sender
+	Cmd    string    `json:"cmd"`
+	Target string    `json:"target"`
+	Text   string    `json:"text"`
+	Sig    string    `json:"sig,omitempty"` // how its signature checked out
+}
+
+// NewHistory returns a History storing its logs in dir, creating dir if
+// needed.
+func NewHistory(dir string, lines int) (*History, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if lines <= 0 {
+		lines = DefaultHistoryLines
+	}
+	return &History{Dir: dir, Lines: lines, count: make(map[string]int)}, nil
+}
+
+// historyKey returns the log a message to target belongs in: the
+// channel, or for private messages between callsign and peerCallsign
+// the two base callsigns in sorted order. Keying private logs by
+// callsign keeps them with their owners through nick changes, and away
+// from whoever takes a nick next.
+func historyKey(target, callsign, peerCallsign string) string {
+	if strings.HasPrefix(target, "#") {
+		return channelKey(target)
+	}
+	pair := []string{baseCallsign(callsign), baseCallsign(peerCallsign)}
+	slices.Sort(pair)
+	return pair[0] + "," + pair[1]
+}
+
+func (h *History) path(key string) string {
+	return filepath.Join(h.Dir, url.PathEscape(key)+".jsonl")
+}
+
+// add appends an entry to the log for key. Once the log grows a quarter
+// past Lines it is rewritten with just the newest Lines entries, so the
+// cost of trimming is spread out.
+func (h *History) add(key string, e historyEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	path := h.path(key)
+	n, ok := h.count[key]
+	if !ok {
+		entries, err := readHistory(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		n = len(entries)
+	}
+
+	fh, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write(append(data, '\n'))
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	n++
+
+	if n > h.Lines+h.Lines/4 {
+		entries, err := readHistory(path)
+		if err != nil {
+			return err
+		}
+		entries = entries[max(0, len(entries)-h.Lines):]
+		if err := writeHistory(path, entries); err != nil {
+			return err
+		}
+		n = len(entries)
+	}
+	h.count[key] = n
+	return nil
+}
+
+// entries returns the stored entries for key, oldest first.
+func (h *History) entries(key string) ([]historyEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, err := readHistory(h.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return entries, err
+}
+
+// between returns up to limit entries for key sent strictly after after
+// and strictly before before; a zero time is no bound. If latest is set,
+// the newest such entries are returned, otherwise the oldest. Either
+// way they come oldest first.
+func (h *History) between(key string, after, before time.Time, limit int, latest bool) ([]historyEntry, error) {
+	entries, err := h.entries(key)
+	if err != nil {
+		return nil, err
+	}
+	entries = slices.DeleteFunc(entries, func(e historyEntry) bool {
+		return !after.IsZero() && !e.Time.After(after) || !before.IsZero() && !e.Time.Before(before)
+	})
+	if len(entries) > limit {
+		if latest {
+			entries = entries[len(entries)-limit:]
+		} else {
+			entries = entries[:limit]
+		}
+	}
+	return entries, nil
+}
+
+func readHistory(path string) ([]historyEntry, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		var e historyEntry
+		// skip lines cut short by a crash rather than lose the log
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func writeHistory(path string, entries []historyEntry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// record stores a message delivered to local users, if history is on.
+func (s *Server) record(at time.Time, sender *User, tags wireTags, cmd, target, text string) {
+	if s.History == nil || (cmd != "PRIVMSG" && cmd != "NOTICE") {
+		return
+	}
+	var peerCallsign string
+	if !strings.HasPrefix(target, "#") {
+		peer := s.Nick(target)
+		if peer == nil {
+			return
+		}
+		peerCallsign = peer.Callsign
+	}
+	e := historyEntry{Time: at.UTC(), From: sender.ID(), Cmd: cmd, Target: target, Text: text, Sig: tags[sigStatusTag]}
+	if err := s.History.add(historyKey(target, sender.Callsign, peerCallsign), e); err != nil {
+		s.debugf("could not store history for %s: %s", target, err)
+	}
+}
+
+// writeHistory sends entries to w in a chathistory batch, for clients
+// that negotiated batch and server-time to tell them apart from new
+// messages.
+func (s *Server) writeHistory(w io.Writer, target string, entries []historyEntry) {
+	ref := fmt.Sprintf("%06x", rand.N(0x1000000))
+	fmt.Fprintf(w, ":%s BATCH +%s chathistory %s\r\n", s.Name, ref, target)
+	for _, e := range entries {
+		tags := wireTags{timeTag: serverTime(e.Time), "batch": ref}
+		if e.Sig != "" {
+			tags[sigStatusTag] = e.Sig
+		}
+		fmt.Fprintf(w, "%s:%s %s %s :%s\r\n", tags, e.From, e.Cmd, e.Target, e.Text)
+	}
+	fmt.Fprintf(w, ":%s BATCH -%s\r\n", s.Name, ref)
+}
+
+// replayHistory sends a local user who joined channel its last
+// ReplayLines messages.
+func (s *Server) replayHistory(user *User, channel string) {
+	if s.History == nil || s.ReplayLines <= 0 {
+		return
+	}
+	entries, err := s.History.between(channelKey(channel), time.Time{}, time.Time{}, s.ReplayLines, true)
+	if err != nil {
+		s.debugf("could not read history for %s: %s", channel, err)
+		return
+	}
+	if len(entries) > 0 {
+		s.writeHistory(user, channel, entries)
+	}
+}
+
+// chathistory handles the IRCv3 CHATHISTORY command. Only timestamp
+// references are supported; hamirc does not assign message IDs.
+func (s *Server) chathistory(c *client, args []string) (quit bool) {
+	user := c.user
+	fail := func(code, context, description string) {
+		fmt.Fprintf(c, ":%s FAIL CHATHISTORY %s %s :%s\r\n", s.Name, code, context, description)
+	}
+	if s.History == nil {
+		s.reply(user, ERR_UNKNOWNCOMMAND, user.Nick, "CHATHISTORY", "History is not enabled")
+		return
+	}
+	if len(args) < 4 {
+		fail("NEED_MORE_PARAMS", strings.Join(args[1:], " "), "Not enough parameters")
+		return
+	}
+	sub, target := strings.ToUpper(args[1]), args[2]
+	var peerCallsign string
+	if !strings.HasPrefix(target, "#") {
+		peer := s.Nick(target)
+		if peer == nil {
+			fail("INVALID_TARGET", sub+" "+target, "No such target")
+			return
+		}
+		// the log is the requester's own conversation with peer, and
+		// anyone can claim a callsign, so they must prove they hold it
+		if !user.authenticated() {
+			fail("INVALID_TARGET", sub+" "+target, fmt.Sprintf("Private history is only available after logging in as %s", baseCallsign(user.Callsign)))
+			return
+		}
+		peerCallsign = peer.Callsign
+	}
+
+	limitArg := args[len(args)-1]
+	limit, err := strconv.Atoi(limitArg)
+	if err != nil || limit < 1 {
+		fail("INVALID_PARAMS", sub+" "+limitArg, "Invalid limit")
+		return
+	}
+	limit = min(limit, historyMaxLimit)
+
+	refs := make([]time.Time, 0, 2)
+	for _, ref := range args[3 : len(args)-1] {
+		if ref == "*" && sub == "LATEST" {
+			refs = append(refs, time.Time{})
+			continue
+		}
+		ts, ok := strings.CutPrefix(ref, "timestamp=")
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if !ok || err != nil {
+			fail("INVALID_PARAMS", sub+" "+ref, "Only timestamp= references are supported")
+			return
+		}
+		refs = append(refs, t)
+	}
+
+	var after, before time.Time
+	latest := false
+	switch {
+	case sub == "LATEST" && len(refs) == 1:
+		after, latest = refs[0], true
+	case sub == "BEFORE" && len(refs) == 1:
+		before, latest = refs[0], true
+	case sub == "AFTER" && len(refs) == 1:
+		after = refs[0]
+	case sub == "BETWEEN" && len(refs) == 2:
+		after, before = refs[0], refs[1]
+		if after.After(before) {
+			after, before, latest = before, after, true
+		}
+	default:
+		fail("INVALID_PARAMS", sub, "Unknown subcommand or wrong number of parameters")
+		return
+	}
+
+	entries, err := s.History.between(historyKey(target, user.Callsign, peerCallsign), after, before, limit, latest)
+	if err != nil {
+		fail("MESSAGE_ERROR", sub+" "+target, "Could not read history")
+		return
+	}
+	s.writeHistory(c, target, entries)
+	return
+}
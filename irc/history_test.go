@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryTrim(t *testing.T) {
+	h, err := NewHistory(t.TempDir(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 25 {
+		e := historyEntry{Time: start.Add(time.Duration(i) * time.Minute), From: "n!K1ABC@x", Cmd: "PRIVMSG", Target: "#net", Text: fmt.Sprint(i)}
+		if err := h.add("#net", e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := h.entries("#net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 8 || len(entries) > 10 || entries[len(entries)-1].Text != "24" {
+		t.Fatalf("kept %d entries ending %+v, want 8 to 10 ending with 24", len(entries), entries[len(entries)-1])
+	}
+
+	got, _ := h.between("#net", start.Add(19*time.Minute), start.Add(23*time.Minute), 2, false)
+	if len(got) != 2 || got[0].Text != "20" || got[1].Text != "21" {
+		t.Errorf("oldest 2 between 19 and 23 = %+v", got)
+	}
+	got, _ = h.between("#net", start.Add(19*time.Minute), start.Add(23*time.Minute), 2, true)
+	if len(got) != 2 || got[0].Text != "21" || got[1].Text != "22" {
+		t.Errorf("latest 2 between 19 and 23 = %+v", got)
+	}
+}
+
+func TestHistoryKey(t *testing.T) {
+	if historyKey("alice", "K1ABC-7", "w1aw") != historyKey("bob", "W1AW", "K1ABC") {
+		t.Error("private messages in each direction use different logs")
+	}
+	if historyKey("#Net", "K1ABC", "") != "#net" {
+		t.Errorf("historyKey for a channel = %q", historyKey("#Net", "K1ABC", ""))
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	s := NewServer()
+	var err error
+	if s.History, err = NewHistory(t.TempDir(), 100); err != nil {
+		t.Fatal(err)
+	}
+	s.ReplayLines = 1
+
+	remote := NewUser("far", nil)
+	remote.Callsign = "W1AW"
+	s.Users[nickKey(remote.Nick)] = remote
+	s.joinChannel(remote, "#net")
+	at := time.Date(2024, 5, 1, 15, 0, 0, 0, time.UTC)
+	s.sendTagged(remote, at, nil, "PRIVMSG", "#net", "first")
+	s.sendTagged(remote, at.Add(time.Minute), nil, "PRIVMSG", "#net", "second")
+
+	conn, r := testClient(t, s,
+		"CAP REQ :batch server-time", "CAP END", "NICK ham", "USER K1ABC 0 * :Ham",
+		"JOIN #net",
+		"CHATHISTORY BEFORE #net timestamp=2024-05-01T15:01:00.000Z 10",
+		"CHATHISTORY AFTER #net msgid=abc 10")
+	expect(t, conn, r, "CHATHISTORY=100")
+	// replay on JOIN gets the last line only
+	expect(t, conn, r, "BATCH +")
+	expect(t, conn, r, ";time=2024-05-01T15:01:00.000Z :far!W1AW@ PRIVMSG #net :second")
+	expect(t, conn, r, "BATCH -")
+	expect(t, conn, r, "time=2024-05-01T15:00:00.000Z :far!W1AW@ PRIVMSG #net :first")
+	expect(t, conn, r, "FAIL CHATHISTORY INVALID_PARAMS")
+}
+
+func TestPrivateHistory(t *testing.T) {
+	s := NewServer()
+	var err error
+	if s.History, err = NewHistory(t.TempDir(), 100); err != nil {
+		t.Fatal(err)
+	}
+	s.Credentials = Credentials{"K1ABC": HashPassword("hunter2"), "W2XYZ": HashPassword("letmein")}
+	remote := NewUser("far", nil)
+	remote.Callsign = "W1AW"
+	s.Users[nickKey(remote.Nick)] = remote
+
+	conn, r := testClient(t, s, "PASS hunter2", "NICK ham", "USER K1ABC 0 * :Ham")
+	expect(t, conn, r, RPL_WELCOME)
+	s.sendTagged(remote, time.Now(), nil, "PRIVMSG", "ham", "secret")
+	expect(t, conn, r, "PRIVMSG ham :secret")
+
+	// history follows the callsign through a nick change
+	fmt.Fprintf(conn, "NICK ham2\r\nCHATHISTORY LATEST far * 10\r\n")
+	expect(t, conn, r, "PRIVMSG ham :secret")
+	fmt.Fprintf(conn, "QUIT\r\n")
+	conn.Close()
+	for s.Nick("ham2") != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	// claiming the callsign without logging in is not enough
+	conn, r = testClient(t, s, "NICK ham", "USER K1ABC 0 * :Ham", "CHATHISTORY LATEST far * 10")
+	expect(t, conn, r, "FAIL CHATHISTORY INVALID_TARGET LATEST far")
+	fmt.Fprintf(conn, "QUIT\r\n")
+	conn.Close()
+	for s.Nick("ham") != nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	conn, r = testClient(t, s, "CAP REQ :batch", "CAP END", "PASS letmein", "NICK ham", "USER W2XYZ 0 * :Not Ham", "CHATHISTORY LATEST far * 10")
+	expect(t, conn, r, "BATCH +")
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.Contains(line, "BATCH -") {
+		t.Fatalf("new owner of the nick got %q", line)
+	}
+}
@@ -0,0 +1,124 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sparques/hamirc/ax25"
+)
+
+const (
+	// DefaultIDInterval is the longest the station transmits without
+	// identifying, matching the US 10 minute rule.
+	DefaultIDInterval = 10 * time.Minute
+
+	// idSessionIdle is how long the station has to be quiet before a
+	// transmission session is considered over and the closing ID is
+	// sent.
+	idSessionIdle = 3 * time.Minute
+
+	idTick = 5 * time.Second
+)
+
+// idDest is the destination address of station ID frames, as used by
+// Direwolf and most TNC beacons.
+var idDest = ax25.Address{Call: "ID"}
+
+// ider keeps track of when the station transmits so it can send a
+// station identification frame at least every interval while
+// transmitting and once more at the end of each transmission session.
+type ider struct {
+	mu       sync.Mutex
+	started  time.Time // start of the current session; zero if idle
+	lastTX   time.Time
+	lastID   time.Time
+	callsign []string // callsigns transmitted for since the last ID
+}
+
+// transmitted records a transmission made for callsign.
+func (id *ider) transmitted(callsign string, now time.Time) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.started.IsZero() {
+		id.started = now
+	}
+	id.lastTX = now
+	callsign = strings.ToUpper(callsign)
+	if !slices.Contains(id.callsign, callsign) {
+		id.callsign = append(id.callsign, callsign)
+	}
+}
+
+// due reports whether an ID should be sent now and, if so, returns the
+// callsigns to include in it.
+func (id *ider) due(now time.Time, interval time.Duration) (callsigns []string, ok bool) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.started.IsZero() {
+		return nil, false
+	}
+
+	sessionOver := now.Sub(id.lastTX) >= idSessionIdle
+	since := id.started
+	if id.lastID.After(since) {
+		since = id.lastID
+	}
+	switch {
+	case sessionOver && id.lastID.After(id.lastTX):
+		// already identified after the last transmission
+	case sessionOver, now.Sub(since) >= interval:
+		callsigns = id.callsign
+		id.callsign = nil
+		id.lastID = now
+		ok = true
+	default:
+		return nil, false
+	}
+	if sessionOver {
+		id.started = time.Time{}
+	}
+	return callsigns, ok
+}
+
+// identify sends station ID frames for as long as the server runs.
+func (s *Server) identify() {
+	interval := s.IDInterval
+	if interval <= 0 {
+		interval = DefaultIDInterval
+	}
+	for now := range time.Tick(idTick) {
+		callsigns, ok := s.ider.due(now, interval)
+		if !ok {
+			continue
+		}
+		if err := s.sendID(callsigns); err != nil {
+			log.Printf("Could not send station ID: %s", err)
+		}
+	}
+}
+
+func (s *Server) sendID(callsigns []string) error {
+	src, err := ax25.ParseAddress(s.IDCall)
+	if err != nil {
+		return err
+	}
+	text := fmt.Sprintf("%s hamirc station ID", src.Call)
+	if len(callsigns) > 0 {
+		text += "; operators: " + strings.Join(callsigns, " ")
+	}
+	frame := ax25.UI(idDest, src, []byte(text))
+	frame.Path = s.Path
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s.debugf("<TNC> identifying: %s", text)
+	now := time.Now()
+	s.chargeAirtime([][]byte{data}, now)
+	_, err = s.txq.push(&txFrame{src: s.IDCall, data: data}, txUrgent, s.MTU, now)
+	return err
+}
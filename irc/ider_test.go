@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIDerSchedule(t *testing.T) {
+	var id ider
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	interval := 10 * time.Minute
+
+	if _, ok := id.due(start, interval); ok {
+		t.Fatal("ID due before anything was transmitted")
+	}
+
+	// a long session: one ID once the interval has passed
+	for m := range 10 {
+		id.transmitted("k1abc", start.Add(time.Duration(m)*time.Minute))
+	}
+	id.transmitted("W1AW", start.Add(9*time.Minute))
+	if _, ok := id.due(start.Add(9*time.Minute), interval); ok {
+		t.Fatal("ID due before the interval passed")
+	}
+	calls, ok := id.due(start.Add(10*time.Minute), interval)
+	if !ok {
+		t.Fatal("no ID after the interval passed")
+	}
+	if !slices.Equal(calls, []string{"K1ABC", "W1AW"}) {
+		t.Fatalf("ID callsigns = %v", calls)
+	}
+	id.transmitted("K1ABC", start.Add(11*time.Minute))
+	if _, ok := id.due(start.Add(11*time.Minute), interval); ok {
+		t.Fatal("ID sent twice within the interval")
+	}
+
+	// end of session: last transmission at 11m, so closing ID once idle
+	end := start.Add(11*time.Minute + idSessionIdle)
+	calls, ok = id.due(end, interval)
+	if !ok {
+		t.Fatal("no ID at the end of the session")
+	}
+	if !slices.Equal(calls, []string{"K1ABC"}) {
+		t.Fatalf("closing ID callsigns = %v", calls)
+	}
+	if _, ok := id.due(end.Add(time.Hour), interval); ok {
+		t.Fatal("ID sent with no session in progress")
+	}
+}
+
+func TestIDerSkipsClosingIDWhenAlreadyIdentified(t *testing.T) {
+	var id ider
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	id.transmitted("K1ABC", start)
+	if _, ok := id.due(start.Add(10*time.Minute), 10*time.Minute); !ok {
+		t.Fatal("no ID")
+	}
+	if _, ok := id.due(start.Add(10*time.Minute+idSessionIdle), 10*time.Minute); ok {
+		t.Fatal("closing ID sent although nothing was transmitted since the last ID")
+	}
+}
@@ -0,0 +1,182 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sparques/hamirc/kiss"
+)
+
+const (
+	// LinkQueueDepth is how many outgoing frames are held while the
+	// TNC is unreachable before further frames are refused.
+	LinkQueueDepth = 64
+
+	linkMinBackoff = time.Second
+	linkMaxBackoff = 2 * time.Minute
+)
+
+var ErrLinkQueueFull = errors.New("transmit queue is full")
+
+// tncLink supervises the connection to a KISS TNC. When the connection
+// is lost it is redialed with exponential backoff; frames sent in the
+// meantime wait in a bounded queue.
+type tncLink struct {
+	addr   string
+	dial   func() (io.ReadWriteCloser, error)
+	portID uint8
+	params kiss.Params
+	// onState is called whenever the link goes up or down.
+	onState func(up bool)
+
+	out    chan []byte
+	frames chan []byte
+
+	mu    sync.Mutex
+	conn  io.ReadWriteCloser
+	port  *kiss.Port
+	ready chan struct{} // closed while the link is up
+}
+
+func newTNCLink(addr string, portID uint8, params kiss.Params, dial func() (io.ReadWriteCloser, error)) *tncLink {
+	return &tncLink{
+		addr:   addr,
+		dial:   dial,
+		portID: portID,
+		params: params,
+		out:    make(chan []byte, LinkQueueDepth),
+		frames: make(chan []byte, kiss.QueueDepth),
+		ready:  make(chan struct{}),
+	}
+}
+
+// start brings the link up on conn, which must already be connected,
+// and supervises it from then on.
+func (l *tncLink) start(conn io.ReadWriteCloser) {
+	go l.run(conn)
+	go l.writer()
+}
+
+// Send queues a frame for transmission. Frames are held while the link
+// is down; ErrLinkQueueFull is returned once the queue is full.
+func (l *tncLink) Send(frame []byte) error {
+	select {
+	case l.out <- frame:
+		return nil
+	default:
+		return ErrLinkQueueFull
+	}
+}
+
+// Frames returns the channel received frames are delivered on.
+func (l *tncLink) Frames() <-chan []byte {
+	return l.frames
+}
+
+// Up reports whether the TNC is currently connected.
+func (l *tncLink) Up() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.port != nil
+}
+
+// Port returns the TNC port while the link is up and nil otherwise.
+func (l *tncLink) Port() *kiss.Port {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.port
+}
+
+func (l *tncLink) run(conn io.ReadWriteCloser) {
+	backoff := linkMinBackoff
+	for {
+		if conn == nil {
+			var err error
+			conn, err = l.dial()
+			if err != nil {
+				log.Printf("Could not reconnect to TNC at %s, retrying in %s: %s", l.addr, backoff, err)
+				time.Sleep(backoff)
+				backoff = min(backoff*2, linkMaxBackoff)
+				continue
+			}
+			log.Printf("Reconnected to TNC at %s", l.addr)
+		}
+		backoff = linkMinBackoff
+
+		tnc := kiss.NewTNC(conn)
+		port := tnc.Port(l.portID)
+		if err := port.Apply(l.params); err != nil {
+			log.Printf("could not set TNC parameters: %s", err)
+		}
+		l.setUp(conn, port)
+
+		for {
+			frame, err := port.ReadFrame(context.Background())
+			if err != nil {
+				log.Printf("error reading from TNC port: %s", err)
+				break
+			}
+			l.frames <- frame
+		}
+
+		l.setDown(conn)
+		conn = nil
+	}
+}
+
+// writer drains the outbound queue into whichever TNC connection is
+// current, holding on to a frame until it has been written.
+func (l *tncLink) writer() {
+	for frame := range l.out {
+		for {
+			l.mu.Lock()
+			conn, port, ready := l.conn, l.port, l.ready
+			l.mu.Unlock()
+			if port == nil {
+				<-ready
+				continue
+			}
+			err := port.WriteFrame(frame)
+			if err == nil {
+				break
+			}
+			log.Printf("error writing to TNC port: %s", err)
+			l.setDown(conn)
+		}
+	}
+}
+
+func (l *tncLink) setUp(conn io.ReadWriteCloser, port *kiss.Port) {
+	l.mu.Lock()
+	l.conn = conn
+	l.port = port
+	close(l.ready)
+	l.mu.Unlock()
+
+	if l.onState != nil {
+		l.onState(true)
+	}
+}
+
+// setDown marks the link down if conn is still the current connection.
+// Closing conn makes the reader notice, which starts the redial.
+func (l *tncLink) setDown(conn io.ReadWriteCloser) {
+	l.mu.Lock()
+	if l.conn != conn || l.port == nil {
+		l.mu.Unlock()
+		return
+	}
+	l.conn = nil
+	l.port = nil
+	l.ready = make(chan struct{})
+	l.mu.Unlock()
+
+	conn.Close()
+	if l.onState != nil {
+		l.onState(false)
+	}
+}
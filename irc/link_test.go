@@ -0,0 +1,67 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sparques/hamirc/kiss"
+)
+
+func TestLinkQueuesWhileDownAndRedials(t *testing.T) {
+	first, firstTNC := net.Pipe()
+	second, secondTNC := net.Pipe()
+	defer secondTNC.Close()
+
+	dials := make(chan struct{}, 1)
+	link := newTNCLink("test", 0, kiss.Params{}, func() (io.ReadWriteCloser, error) {
+		dials <- struct{}{}
+		return second, nil
+	})
+	states := make(chan bool, 4)
+	link.onState = func(up bool) { states <- up }
+
+	link.start(first)
+	if up := <-states; !up {
+		t.Fatal("link did not come up")
+	}
+
+	// the TNC goes away; queue a frame before the link is redialed
+	firstTNC.Close()
+	if up := <-states; up {
+		t.Fatal("link did not go down")
+	}
+	if err := link.Send([]byte("queued frame")); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	select {
+	case <-dials:
+	case <-time.After(time.Second):
+		t.Fatal("link was not redialed")
+	}
+
+	scanner := bufio.NewScanner(secondTNC)
+	scanner.Split(kiss.Split)
+	if !scanner.Scan() {
+		t.Fatalf("no frame written after reconnect: %v", scanner.Err())
+	}
+	if got := scanner.Bytes(); !bytes.HasPrefix(got[1:], []byte("queued frame")) {
+		t.Fatalf("frame = %q, want queued frame", got)
+	}
+}
+
+func TestLinkQueueIsBounded(t *testing.T) {
+	link := newTNCLink("test", 0, kiss.Params{}, nil)
+	for range LinkQueueDepth {
+		if err := link.Send([]byte("x")); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := link.Send([]byte("x")); err != ErrLinkQueueFull {
+		t.Fatalf("Send on full queue = %v, want ErrLinkQueueFull", err)
+	}
+}
@@ -0,0 +1,107 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultPingInterval is how often local clients are sent a PING.
+	DefaultPingInterval = 2 * time.Minute
+
+	// DefaultPingTimeout is how long a client may leave a PING
+	// unanswered, while sending nothing else, before it is dropped.
+	DefaultPingTimeout = 3 * time.Minute
+
+	pingTick = 10 * time.Second
+)
+
+// PingPong pings local clients and drops the ones that stop answering,
+// for as long as the server runs.
+func (s *Server) PingPong() {
+	for now := range time.Tick(pingTick) {
+		s.checkPings(now)
+	}
+}
+
+// checkPings sends due PINGs and drops clients that timed out.
+func (s *Server) checkPings(now time.Time) {
+	interval, timeout := s.PingInterval, s.PingTimeout
+	if interval <= 0 {
+		interval = DefaultPingInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+
+	s.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.Unlock()
+
+	for _, c := range clients {
+		c.mu.Lock()
+		var ping, timedOut bool
+		switch {
+		case c.closing:
+		case !c.pingSent.IsZero() && now.Sub(latest(c.pingSent, c.lastActive)) >= timeout:
+			timedOut = true
+			c.quitReason = fmt.Sprintf("Ping timeout: %d seconds", int(now.Sub(c.lastActive).Seconds()))
+		case now.Sub(c.lastPing) >= interval:
+			ping = true
+			c.lastPing = now
+			if c.pingSent.IsZero() {
+				c.pingSent = now
+			}
+		}
+		reason := c.quitReason
+		c.mu.Unlock()
+
+		switch {
+		case timedOut:
+			log.Printf("<%s@%s> %s", c.user.Nick, c.remoteAddr(), reason)
+			fmt.Fprintf(c, "ERROR :Closing link: %s\r\n", reason)
+			// closing the connection ends its reader, which cleans up
+			go c.close()
+		case ping:
+			fmt.Fprintf(c, "PING :LAG%d\r\n", now.Unix())
+		}
+	}
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// seen records a line from the client at now. A PONG answers the
+// oldest outstanding PING and gives the client's lag.
+func (c *client) seen(now time.Time, pong bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActive = now
+	if pong && !c.pingSent.IsZero() {
+		c.lag = now.Sub(c.pingSent)
+		c.measured = true
+		c.pingSent = time.Time{}
+	}
+}
+
+// lag returns the largest lag measured for the user's clients.
+func (u *User) lag() (lag time.Duration, ok bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, c := range u.clients {
+		c.mu.Lock()
+		if c.measured {
+			lag, ok = max(lag, c.lag), true
+		}
+		c.mu.Unlock()
+	}
+	return lag, ok
+}
@@ -0,0 +1,65 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPingTimeout(t *testing.T) {
+	s := NewServer()
+	ham, hr := testClient(t, s, "NICK ham", "USER K1ABC 0 * :Ham", "JOIN #net")
+	expect(t, ham, hr, RPL_ENDOFNAMES)
+	friend, fr := testClient(t, s, "NICK friend", "USER W1AW 0 * :Hiram", "JOIN #net")
+	expect(t, friend, fr, RPL_ENDOFNAMES)
+
+	var hamClient, friendClient *client
+	s.Lock()
+	for c := range s.clients {
+		switch c.user.Nick {
+		case "ham":
+			hamClient = c
+		case "friend":
+			friendClient = c
+		}
+	}
+	s.Unlock()
+
+	start := time.Now()
+	s.checkPings(start.Add(s.PingInterval))
+	expect(t, ham, hr, "PING :LAG")
+	expect(t, friend, fr, "PING :LAG")
+	friend.Write([]byte("PONG :LAG\r\n"))
+	for {
+		friendClient.mu.Lock()
+		answered := friendClient.measured
+		friendClient.mu.Unlock()
+		if answered {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// ham never answered, so it is dropped and friend hears it quit
+	s.checkPings(start.Add(s.PingInterval + s.PingTimeout))
+	expect(t, ham, hr, "ERROR :Closing link: Ping timeout")
+	expect(t, friend, fr, ":ham!K1ABC@Ham QUIT :Ping timeout")
+	for s.Nick("ham") != nil {
+		time.Sleep(time.Millisecond)
+	}
+	s.Lock()
+	_, tracked := s.clients[hamClient]
+	s.Unlock()
+	if tracked {
+		t.Error("timed out client is still tracked")
+	}
+}
+
+func TestClientLag(t *testing.T) {
+	sent := time.Now()
+	c := &client{pingSent: sent}
+	c.seen(sent.Add(150*time.Millisecond), true)
+	u := &User{clients: []*client{c}}
+	if lag, ok := u.lag(); !ok || lag != 150*time.Millisecond {
+		t.Errorf("lag = %s, %v; want 150ms", lag, ok)
+	}
+}
@@ -0,0 +1,105 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Stations tell each other when their users leave a channel, quit or
+// change nick, so that every station's channel lists follow what remote
+// users really do. On the air these are
+//
+//	:nick!CALL@Real_Name PART #channel :reason
+//	:nick!CALL@Real_Name QUIT * :reason
+//	:nick!CALL@Real_Name NICK newnick :
+//
+// and are signed like messages. A QUIT is sent once, not per channel.
+const quitTarget = "*"
+
+// presence reports whether cmd is one of the presence commands.
+func presence(cmd string) bool {
+	return cmd == "PART" || cmd == "QUIT" || cmd == "NICK"
+}
+
+// transmitPresence transmits a presence command for user, a local user,
+// unless presence is off or the user may not transmit.
+func (s *Server) transmitPresence(user *User, cmd, target, text string) {
+	if !user.Local() || !s.Presence || !s.canTransmit(user) {
+		return
+	}
+	s.transmit(user, nil, cmd, target, text)
+}
+
+// channelPeers returns the users sharing a channel with user, other
+// than user.
+func (s *Server) channelPeers(user *User) []*User {
+	s.Lock()
+	defer s.Unlock()
+	var peers []*User
+	for _, ch := range s.Channels {
+		if ch.Users[nickKey(user.Nick)] != user {
+			continue
+		}
+		for _, u := range ch.Users {
+			if u != user {
+				peers = append(peers, u)
+			}
+		}
+	}
+	return uniqueUsers(peers)
+}
+
+// remotePresence applies a PART, QUIT or NICK heard over the radio at
+// heard. It only acts on a remote user already known by that nick and
+// callsign, so no station can part, quit or rename local users or users
+// of other stations.
+func (s *Server) remotePresence(sender *User, tags wireTags, args []string, heard time.Time) {
+	user := s.Nick(sender.Nick)
+	if user == nil || user.Local() || !strings.EqualFold(user.Callsign, sender.Callsign) {
+		s.debugf("<TNC> ignoring %s for unknown user %s", args[1], args[0])
+		return
+	}
+	var text string
+	if len(args) > 3 {
+		text = args[3]
+	}
+
+	switch args[1] {
+	case "PART":
+		s.Lock()
+		ch, ok := s.Channels[channelKey(args[2])]
+		if ok {
+			_, ok = ch.Users[nickKey(user.Nick)]
+		}
+		s.Unlock()
+		if !ok {
+			return
+		}
+		s.sendTagged(user, heard, tags, "PART", ch.Name, text)
+		s.Lock()
+		delete(ch.Users, nickKey(user.Nick))
+		s.Unlock()
+	case "QUIT":
+		s.quit(user, text)
+		s.removeUser(user)
+	case "NICK":
+		if existing := s.Nick(args[2]); existing != nil && existing != user && !existing.Local() &&
+			strings.EqualFold(existing.Callsign, user.Callsign) {
+			// lines sent under the new nick were heard first
+			s.removeUser(existing)
+		}
+		s.changeNick(user, args[2])
+	}
+}
+
+// quit tells the users sharing a channel with user that it has quit,
+// and transmits the QUIT if user is local.
+func (s *Server) quit(user *User, reason string) {
+	s.transmitPresence(user, "QUIT", quitTarget, reason)
+
+	line := fmt.Sprintf("%s:%s QUIT :%s\r\n", wireTags{timeTag: serverTime(time.Now())}, user.ID(), reason)
+	for _, peer := range s.channelPeers(user) {
+		fmt.Fprint(peer, line)
+	}
+}
@@ -0,0 +1,54 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemotePresence(t *testing.T) {
+	s := NewServer()
+	conn, r := testClient(t, s, "NICK local", "USER K1ABC 0 * :Ham", "JOIN #net")
+	expect(t, conn, r, RPL_ENDOFNAMES)
+
+	heard := func(line string) {
+		t.Helper()
+		s.handleRadioLine("", line, time.Now())
+	}
+	inChannel := func(nick string) bool {
+		s.Lock()
+		defer s.Unlock()
+		_, ok := s.Channels[channelKey("#net")].Users[nickKey(nick)]
+		return ok
+	}
+
+	heard(":far!W1AW@Hiram PRIVMSG #net :hello")
+	expect(t, conn, r, "PRIVMSG #net :hello")
+
+	// only the station that owns a nick can part it, and never a local user
+	heard(":far!N0CALL@x PART #net :spoofed")
+	heard(":local!W1AW@Hiram PART #net :spoofed")
+	if !inChannel("far") || !inChannel("local") {
+		t.Fatal("PART from the wrong callsign took effect")
+	}
+
+	heard(":far!W1AW@Hiram NICK faraway :")
+	expect(t, conn, r, ":far NICK :faraway")
+	heard(":faraway!W1AW@Hiram NICK local :")
+	if s.Nick("local") == nil || !s.Nick("local").Local() {
+		t.Fatal("remote NICK took a local user's nick")
+	}
+
+	heard(":faraway!W1AW@Hiram PART #net :later")
+	expect(t, conn, r, "PART #net :later")
+	if inChannel("faraway") {
+		t.Fatal("faraway still in #net after PART")
+	}
+
+	heard(":faraway!W1AW@Hiram PRIVMSG #net :back")
+	expect(t, conn, r, "PRIVMSG #net :back")
+	heard(":faraway!W1AW@Hiram QUIT * :73")
+	expect(t, conn, r, ":faraway!W1AW@Hiram QUIT :73")
+	if s.Nick("faraway") != nil || inChannel("faraway") {
+		t.Fatal("faraway still known after QUIT")
+	}
+}
@@ -0,0 +1,277 @@
+package irc
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sparques/hamirc/ax25"
+)
+
+// radioDest is the AX.25 destination address of every frame hamirc
+// transmits. Frames addressed elsewhere (APRS, BBS traffic, etc.) are
+// ignored.
+var radioDest = ax25.Address{Call: "HAMIRC"}
+
+// transmit sends an IRC message over the radio in AX.25 UI frames,
+// fragmenting it if it is longer than the MTU. The source address is
+// the callsign of the user the message is sent for, and the message is
+// signed if the user has a signing key. It returns the lines
+// transmitted, or nil if nothing was.
+func (s *Server) transmit(user *User, tags wireTags, cmd, target, text string) []string {
+	if s.link == nil {
+		return nil
+	}
+	tags = s.signTags(user, tags, cmd, target, text)
+	fragID := fmt.Sprintf("%04x", rand.N(0x10000))
+	lines := fragment(tags, user.ID(), cmd, target, text, s.MTU, fragID)
+	if !s.transmitLines(user, lines) {
+		return nil
+	}
+	return lines
+}
+
+// transmitLines sends radio lines, one per frame, for user. Lines over
+// the airtime budget are held back until there is airtime for them, or
+// refused if that would take too long.
+func (s *Server) transmitLines(user *User, lines []string) bool {
+	if s.link == nil {
+		return false
+	}
+	frames := make([][]byte, len(lines))
+	for i, line := range lines {
+		data, err := s.encodeFrame(user.Callsign, line)
+		if err != nil {
+			s.notTransmitted(user, err)
+			return false
+		}
+		frames[i] = data
+	}
+
+	wait, err := s.reserveAirtime(user.Callsign, frames, time.Now())
+	if err != nil {
+		s.stats.add("messages refused for airtime", 1)
+		s.notTransmitted(user, err)
+		return false
+	}
+	if wait > 0 {
+		s.stats.add("messages delayed for airtime", 1)
+		s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("Over your airtime budget; your message will be transmitted in about %s.", roundWait(wait)))
+		time.AfterFunc(wait, func() { s.queueLines(user, lines) })
+		return true
+	}
+	return s.queueLines(user, lines)
+}
+
+func (s *Server) notTransmitted(user *User, err error) {
+	log.Printf("Not transmitting for %s: %s", user.Nick, err)
+	s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("Not transmitted: %s", err))
+}
+
+func (s *Server) encodeFrame(callsign, line string) ([]byte, error) {
+	src, err := ax25.ParseAddress(callsign)
+	if err != nil {
+		return nil, fmt.Errorf("callsign %q cannot be used as an AX.25 address: %w", callsign, err)
+	}
+	frame := ax25.UI(radioDest, src, []byte(line))
+	frame.Path = s.Path
+	return frame.MarshalBinary()
+}
+
+// decodeFrame extracts the IRC line carried by a received frame, and
+// the station that sent it. ok is false if the frame is not hamirc
+// traffic. Legacy bare-text frames have no source.
+func (s *Server) decodeFrame(data []byte) (src, info string, ok bool) {
+	frame, err := ax25.Decode(data)
+	if err != nil {
+		if s.Legacy {
+			return "", string(data), true
+		}
+		s.debugf("<TNC> dropping undecodable frame: %s", err)
+		return "", "", false
+	}
+	if !frame.IsUI() || frame.PID != ax25.PIDNoLayer3 || frame.Dst.Call != radioDest.Call {
+		s.debugf("<TNC> ignoring frame %s", frame)
+		return "", "", false
+	}
+	s.debugf("<TNC> %s", frame)
+	return frame.Src.Call, string(frame.Info), true
+}
+
+func (s *Server) handleTNC() {
+	if s.link == nil {
+		return
+	}
+	// read incoming messages
+	for frame := range s.link.Frames() {
+		heard := time.Now()
+		src, info, ok := s.decodeFrame(frame)
+		if !ok {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimRight(info, "\x00\r\n"), lineSeparator) {
+			s.handleRadioLine(src, line, heard)
+		}
+	}
+}
+
+// handleRadioLine processes one line heard over the radio at heard in
+// a frame from src. The callsign in the line's prefix must be src's, so
+// a station cannot speak for another; an empty src, for legacy frames,
+// is not checked.
+func (s *Server) handleRadioLine(src, line string, heard time.Time) {
+	clean, reason := cleanRadioLine(line)
+	if reason != "" {
+		s.rejectRadioLine(line, reason)
+		return
+	}
+	tags, line := splitTags(clean)
+	// retransmissions of acknowledged messages must reach ackReceived,
+	// which ACKs them again and drops the copies itself
+	if s.DedupWindow > 0 && tags[ackTag] == "" && s.dedup.duplicate(clean, heard, s.DedupWindow) {
+		s.debugf("<TNC> suppressing duplicate %q", clean)
+		s.stats.add("duplicate lines suppressed", 1)
+		return
+	}
+	args := parse(line)
+
+	s.debugf("<TNC> %v %v", tags, args)
+
+	if reason := checkRadioArgs(tags, args); reason != "" {
+		s.rejectRadioLine(line, reason)
+		return
+	}
+	if src != "" && baseCallsign(senderCallsign(args[0])) != baseCallsign(src) {
+		s.rejectRadioLine(line, "source mismatch")
+		return
+	}
+
+	if args[1] == "ACK" {
+		s.handleAckLine(tags, args, heard)
+		return
+	}
+
+	// only let messages, topics and presence through
+	if !slices.Contains([]string{"PRIVMSG", "NOTICE", "TOPIC", "PART", "QUIT", "NICK"}, args[1]) {
+		return
+	}
+
+	if _, ok := tags[fragmentTag]; ok {
+		s.fragments.add(tags, args, heard, s.FragmentTimeout, s.deliverRadio)
+		return
+	}
+	s.deliverRadio(tags, args, heard)
+}
+
+// rejectRadioLine drops a malformed line heard over the radio, counting
+// it by reason.
+func (s *Server) rejectRadioLine(line, reason string) {
+	s.debugf("<TNC> rejecting line (%s): %q", reason, line)
+	s.stats.add("frames rejected ("+reason+")", 1)
+}
+
+// deliverRadio delivers a complete message heard over the radio to
+// local users, tracking the remote user and channel along the way.
+// Clients that negotiated server-time see heard as the message time.
+func (s *Server) deliverRadio(tags wireTags, args []string, heard time.Time) {
+	// track seen users
+	incomingUser := NewUser("", io.Discard)
+	incomingUser.Parse(args[0])
+
+	if incomingUser.Nick == "" {
+		return
+	}
+	ok, flag := s.checkRemoteCallsign(incomingUser.Callsign)
+	if !ok {
+		return
+	}
+	if b := s.banned(args[0], incomingUser.Callsign, args[2], strings.Join(args[3:], " ")); b != nil {
+		s.debugf("<TNC> dropping message from %s banned by %s", args[0], b.Mask)
+		s.stats.add("banned messages dropped", 1)
+		return
+	}
+	var clientTags wireTags
+	if len(s.Keyring) > 0 {
+		status := s.checkSignature(tags, args, incomingUser.Callsign, heard)
+		if status == sigReplayed {
+			s.debugf("<TNC> dropping replayed %s from %s", args[1], args[0])
+			s.stats.add("replayed messages dropped", 1)
+			return
+		}
+		if status == sigForged && presence(args[1]) {
+			s.debugf("<TNC> dropping forged %s from %s", args[1], args[0])
+			s.stats.add("forged presence dropped", 1)
+			return
+		}
+		if status == sigForged && len(args) > 3 {
+			args[3] = forgedMark + args[3]
+		}
+		clientTags = wireTags{sigStatusTag: status}
+	}
+	if presence(args[1]) {
+		s.remotePresence(incomingUser, clientTags, args, heard)
+		return
+	}
+	if flag && len(args) > 3 {
+		args[3] = badCallsignMark + args[3]
+	}
+	// add user to server if not previously seen
+	if existingUser := s.Nick(incomingUser.Nick); existingUser == nil {
+		s.Lock()
+		if existingUser = s.Users[nickKey(incomingUser.Nick)]; existingUser == nil {
+			incomingUser.LastSeen = heard
+			s.Users[nickKey(incomingUser.Nick)] = incomingUser
+		} else {
+			incomingUser = existingUser
+		}
+		s.Unlock()
+	} else {
+		incomingUser = existingUser
+	}
+
+	// if target is channel
+	if strings.HasPrefix(args[2], "#") {
+		// create channel if it doesn't exist
+		ch := s.Channel(args[2])
+
+		// add user to channel if not already there
+		if nil == ch.Nick(incomingUser.Nick) {
+			s.joinChannel(incomingUser, ch.Name)
+		}
+
+		if s.AutoJoin {
+			var usersToJoin []*User
+			s.Lock()
+			for _, u := range s.Users {
+				_, ok := ch.Users[nickKey(u.Nick)]
+				if u.Local() && !ok {
+					usersToJoin = append(usersToJoin, u)
+				}
+			}
+			s.Unlock()
+			for _, u := range usersToJoin {
+				s.joinChannel(u, args[2])
+			}
+		}
+	}
+
+	if args[1] == "TOPIC" {
+		s.setTopic(incomingUser, s.Channel(args[2]), strings.Join(args[3:], " "))
+	} else {
+		if len(args) < 4 {
+			return
+		}
+		if id := tags[ackTag]; id != "" && !strings.HasPrefix(args[2], "#") {
+			if recipient := s.Nick(args[2]); recipient != nil && recipient.Local() {
+				if s.ackReceived(recipient, incomingUser.Nick, id, heard) {
+					return
+				}
+			}
+		}
+		s.sendTagged(incomingUser, heard, clientTags, args[1], args[2], args[3])
+	}
+}
@@ -9,6 +9,12 @@ const (
 	RPL_ISUPPORT = "005" // Supported server features
 	RPL_BOUNCE   = "010" // Bounce to a different server
 
+	RPL_ENDOFSTATS = "219" // End of STATS report
+	RPL_STATSDEBUG = "249" // Free-form STATS line
+
+	RPL_SILELIST      = "271" // Silence list entry
+	RPL_ENDOFSILELIST = "272" // End of silence list
+
 	RPL_USERHOST = "302" // User host information
 	RPL_ISON     = "303" // ISON response
 	RPL_AWAY     = "301" // Away message
@@ -21,6 +27,7 @@ const (
 	RPL_WHOISIDLE     = "317" // WHOIS idle time
 	RPL_ENDOFWHOIS    = "318" // End of WHOIS list
 	RPL_WHOISCHANNELS = "319" // Channels the user is on
+	RPL_WHOISSPECIAL  = "320" // WHOIS free-form information
 
 	RPL_LISTSTART     = "321" // Start of channel listing
 	RPL_LIST          = "322" // Channel listing
@@ -66,13 +73,31 @@ const (
 const (
 	ERR_NOSUCHNICK        = "401"
 	ERR_NOSUCHCHANNEL     = "403"
+	ERR_CANNOTSENDTOCHAN  = "404"
+	ERR_INVALIDCAPCMD     = "410"
 	ERR_UNKNOWNCOMMAND    = "421"
 	ERR_NONICKNAMEGIVEN   = "431"
+	ERR_ERRONEUSNICKNAME  = "432"
 	ERR_NICKNAMEINUSE     = "433"
 	ERR_NOTONCHANNEL      = "442"
 	ERR_NOTREGISTERED     = "451"
 	ERR_NEEDMOREPARAMS    = "461"
 	ERR_ALREADYREGISTERED = "462"
+	ERR_PASSWDMISMATCH    = "464"
+	ERR_NOPRIVILEGES      = "481"
+	ERR_INVALIDUSERNAME   = "468"
 	ERR_UNKNOWNMODE       = "472"
 	ERR_CHANOPRIVSNEEDED  = "482"
+	ERR_SILELISTFULL      = "511"
+)
+
+// IRCv3 SASL numerics
+const (
+	RPL_LOGGEDIN    = "900" // You are now logged in
+	RPL_SASLSUCCESS = "903" // SASL authentication successful
+	ERR_SASLFAIL    = "904" // SASL authentication failed
+	ERR_SASLTOOLONG = "905" // SASL message too long
+	ERR_SASLABORTED = "906" // SASL authentication aborted
+	ERR_SASLALREADY = "907" // You have already authenticated
+	RPL_SASLMECHS   = "908" // Available SASL mechanisms
 )
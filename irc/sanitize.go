@@ -0,0 +1,94 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxNickLen and maxChannelLen bound nicks and channel names, on
+	// the radio and off.
+	maxNickLen    = 30
+	maxChannelLen = 50
+
+	// ircFormatting holds the control characters IRC clients use for
+	// bold, colours and the like, which may appear in message text.
+	ircFormatting = "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f"
+)
+
+// badControl reports whether r is a control character that must never
+// reach an IRC client, above all CR, LF and NUL.
+func badControl(r rune) bool {
+	return (r < 0x20 || r == 0x7f) && !strings.ContainsRune(ircFormatting, r)
+}
+
+// cleanRadioLine strips the NUL padding and line ending a frame's
+// information field may carry, then checks that the rest is safe to put
+// in lines for IRC clients. It returns the line, or why it must be
+// dropped.
+func cleanRadioLine(info string) (line, reason string) {
+	line = strings.TrimRight(info, "\x00\r\n")
+	switch {
+	case !utf8.ValidString(line):
+		return "", "invalid UTF-8"
+	case strings.IndexFunc(line, badControl) >= 0:
+		return "", "control characters"
+	}
+	return line, ""
+}
+
+// checkRadioArgs checks the tags and parsed arguments of a line heard
+// over the radio. It returns why the line must be dropped, or "".
+func checkRadioArgs(tags wireTags, args []string) string {
+	for _, value := range tags {
+		if strings.IndexFunc(value, badControl) >= 0 {
+			return "control characters"
+		}
+	}
+	if len(args) < 3 {
+		return "too few parameters"
+	}
+	nick, rest, ok1 := strings.Cut(args[0], "!")
+	ident, host, ok2 := strings.Cut(rest, "@")
+	if !ok1 || !ok2 || !validNick(nick) || ident == "" || strings.ContainsAny(ident+host, "!@ ") {
+		return "bad prefix"
+	}
+	switch {
+	case args[1] == "QUIT":
+		if args[2] != quitTarget {
+			return "bad target"
+		}
+	case args[1] == "NICK":
+		if !validNick(args[2]) {
+			return "bad target"
+		}
+	case !validTarget(args[2]):
+		return "bad target"
+	}
+	return ""
+}
+
+// validNick reports whether nick follows the IRC rules: a letter or one
+// of []\`_^{|} followed by those, digits or hyphens.
+func validNick(nick string) bool {
+	if nick == "" || len(nick) > maxNickLen {
+		return false
+	}
+	for i, r := range nick {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', strings.ContainsRune("[]\\`_^{|}", r):
+		case i > 0 && (r >= '0' && r <= '9' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validTarget reports whether target is a valid nick or channel name.
+func validTarget(target string) bool {
+	if !strings.HasPrefix(target, "#") {
+		return validNick(target)
+	}
+	return len(target) > 1 && len(target) <= maxChannelLen && !strings.ContainsAny(target, " ,:\x07")
+}
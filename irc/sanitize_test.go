@@ -0,0 +1,52 @@
+package irc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRadioLineValidation(t *testing.T) {
+	tests := []struct {
+		line, reason string
+	}{
+		{":ham!K1ABC@Ham PRIVMSG #net :hi\x00\x00\x00", ""},
+		{":ham!K1ABC@Ham PRIVMSG #net :\x02bold\x02 and \x0304red\r\n", ""},
+		{":ham!K1ABC@Ham PRIVMSG #net :hi\r\nQUIT :owned", "control characters"},
+		{":ham!K1ABC@Ham PRIVMSG #net :hi\x00there", "control characters"},
+		{":ham!K1ABC@Ham PRIVMSG #net :\xff\xfe", "invalid UTF-8"},
+		{"@a=x\\ny :ham!K1ABC@Ham PRIVMSG ham :hi", "control characters"},
+		{":ham!K1ABC@Ham PRIVMSG", "too few parameters"},
+		{"PRIVMSG #net :no prefix", "bad prefix"},
+		{":9ham!K1ABC@Ham PRIVMSG #net :hi", "bad prefix"},
+		{":ham!K1ABC!x@Ham PRIVMSG #net :hi", "bad prefix"},
+		{":ham!K1ABC@Ham PRIVMSG #a,#b :hi", "bad target"},
+		{":ham!K1ABC@Ham PRIVMSG n*ck :hi", "bad target"},
+		{":ham!K1ABC@Ham QUIT * :73", ""},
+		{":ham!K1ABC@Ham QUIT #net :73", "bad target"},
+		{":ham!K1ABC@Ham NICK #net :", "bad target"},
+	}
+	for _, test := range tests {
+		line, reason := cleanRadioLine(test.line)
+		if reason == "" {
+			tags, rest := splitTags(line)
+			reason = checkRadioArgs(tags, parse(rest))
+		}
+		if reason != test.reason {
+			t.Errorf("%q rejected for %q, want %q", test.line, reason, test.reason)
+		}
+	}
+
+	s := NewServer()
+	s.handleRadioLine("", ":ham!K1ABC@Ham PRIVMSG #net :hi\rPRIVMSG #net :boo", time.Now())
+	s.handleRadioLine("", ":ham!K1ABC@Ham PRIVMSG #net :hi\x00\x00", time.Now())
+	if got := s.Stats()["frames rejected (control characters)"]; got != 1 {
+		t.Errorf("rejections counted: %d, want 1", got)
+	}
+	if s.Nick("ham") == nil {
+		t.Error("padded line was not delivered")
+	}
+	s.handleRadioLine("N0CALL", ":far!W1AW@Hiram PRIVMSG #net :it's me", time.Now())
+	if s.Nick("far") != nil || s.Stats()["frames rejected (source mismatch)"] != 1 {
+		t.Error("line claiming another station's callsign was delivered")
+	}
+}
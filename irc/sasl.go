@@ -0,0 +1,202 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// tlsHandshakeTimeout is how long a new TLS connection has to
+	// complete its handshake.
+	tlsHandshakeTimeout = 30 * time.Second
+
+	// saslChunk is the size of a full AUTHENTICATE chunk; a shorter one
+	// ends the message.
+	saslChunk = 400
+
+	// saslMax bounds how much a client may send in one SASL message.
+	saslMax = 4096
+)
+
+// saslMechs returns the SASL mechanisms clients may use.
+func (s *Server) saslMechs() []string {
+	var mechs []string
+	if len(s.Credentials) > 0 {
+		mechs = append(mechs, "PLAIN")
+	}
+	if s.clientCerts {
+		mechs = append(mechs, "EXTERNAL")
+	}
+	return mechs
+}
+
+// authenticate handles the AUTHENTICATE command for client c.
+func (s *Server) authenticate(c *client, args []string) (quit bool) {
+	user := c.user
+	nick := replyNick(user)
+	if user.registered || user.account != "" {
+		s.reply(user, ERR_SASLALREADY, nick, "You have already authenticated")
+		return
+	}
+	if len(args) < 2 {
+		s.reply(user, ERR_NEEDMOREPARAMS, nick, "AUTHENTICATE", "Not enough parameters")
+		return
+	}
+	arg := args[1]
+
+	c.mu.Lock()
+	mech := c.saslMech
+	if arg == "*" || mech == "" {
+		c.saslMech, c.saslBuf = "", nil
+	}
+	c.mu.Unlock()
+
+	switch {
+	case arg == "*":
+		s.reply(user, ERR_SASLABORTED, nick, "SASL authentication aborted")
+		return
+	case mech == "":
+		mechs := s.saslMechs()
+		if !slices.Contains(mechs, strings.ToUpper(arg)) {
+			s.reply(user, RPL_SASLMECHS, nick, strings.Join(mechs, ","), "are available SASL mechanisms")
+			s.reply(user, ERR_SASLFAIL, nick, "SASL authentication failed")
+			return
+		}
+		c.mu.Lock()
+		c.saslMech = strings.ToUpper(arg)
+		c.mu.Unlock()
+		fmt.Fprintf(c, "AUTHENTICATE +\r\n")
+		return
+	}
+
+	c.mu.Lock()
+	if arg != "+" {
+		c.saslBuf = append(c.saslBuf, arg...)
+	}
+	data := c.saslBuf
+	more := len(arg) == saslChunk
+	if !more || len(data) > saslMax {
+		c.saslMech, c.saslBuf = "", nil
+	}
+	c.mu.Unlock()
+	if len(data) > saslMax {
+		s.reply(user, ERR_SASLTOOLONG, nick, "SASL message too long")
+		return
+	}
+	if more {
+		return
+	}
+
+	response, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		s.reply(user, ERR_SASLFAIL, nick, "SASL authentication failed")
+		return
+	}
+	var account string
+	switch mech {
+	case "PLAIN":
+		account = s.saslPlain(response)
+	case "EXTERNAL":
+		account = saslExternal(c, response)
+	}
+	if account == "" {
+		log.Printf("<%s@%s> SASL %s failed", nick, c.remoteAddr(), mech)
+		s.reply(user, ERR_SASLFAIL, nick, "SASL authentication failed")
+		return
+	}
+
+	user.account = account
+	mask := "*"
+	if user.Nick != "" {
+		mask = user.ID()
+	}
+	s.reply(user, RPL_LOGGEDIN, nick, mask, account, "You are now logged in as "+account)
+	s.reply(user, RPL_SASLSUCCESS, nick, "SASL authentication successful")
+	return
+}
+
+// saslPlain checks a PLAIN response, "authzid NUL authcid NUL password",
+// and returns the callsign it authenticates, or "".
+func (s *Server) saslPlain(response []byte) string {
+	fields := bytes.Split(response, []byte{0})
+	if len(fields) != 3 {
+		return ""
+	}
+	authzid, authcid, password := string(fields[0]), string(fields[1]), string(fields[2])
+	if authzid != "" && baseCallsign(authzid) != baseCallsign(authcid) {
+		return ""
+	}
+	if !s.Credentials.Check(authcid, password) {
+		return ""
+	}
+	return baseCallsign(authcid)
+}
+
+// saslExternal returns the callsign in the client's verified TLS
+// certificate, or "". The response may name the callsign to use, which
+// must then match.
+func saslExternal(c *client, response []byte) string {
+	call := c.user.cert
+	if call == "" || len(response) > 0 && baseCallsign(string(response)) != call {
+		return ""
+	}
+	return call
+}
+
+// handshake completes the TLS handshake for a new connection and
+// returns the callsign its verified client certificate was issued for,
+// or "" if it presented none. A verified certificate naming no callsign
+// is an error.
+func (s *Server) handshake(conn *tls.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return "", nil
+	}
+	cert := state.VerifiedChains[0][0]
+	call := certCallsign(cert)
+	if call == "" {
+		return "", fmt.Errorf("certificate for %q names no callsign", cert.Subject.CommonName)
+	}
+	return call, nil
+}
+
+// oidCallsign is the certificate extension Logbook of The World uses
+// to carry the callsign a certificate was issued for.
+var oidCallsign = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 12348, 1, 1}
+
+// certCallsign returns the base callsign a client certificate was
+// issued for, or "". It is taken from the callsign extension if there
+// is one, otherwise from the subject common name.
+func certCallsign(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidCallsign) {
+			continue
+		}
+		var call string
+		// the value is normally a DER string, but take raw text too
+		if rest, err := asn1.Unmarshal(ext.Value, &call); err != nil || len(rest) > 0 {
+			call = string(ext.Value)
+		}
+		if ValidCallsign(call) {
+			return baseCallsign(call)
+		}
+		return ""
+	}
+	if call := cert.Subject.CommonName; ValidCallsign(call) {
+		return baseCallsign(call)
+	}
+	return ""
+}
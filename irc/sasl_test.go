@@ -0,0 +1,144 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSASLPlain(t *testing.T) {
+	s := NewServer()
+	s.Credentials = Credentials{"K1ABC": HashPassword("hunter2")}
+	plain := func(authcid, password string) string {
+		return base64.StdEncoding.EncodeToString([]byte("\x00" + authcid + "\x00" + password))
+	}
+
+	conn, r := testClient(t, s, "CAP LS 302", "CAP REQ :sasl",
+		"AUTHENTICATE PLAIN", "AUTHENTICATE "+plain("K1ABC", "wrong"),
+		"AUTHENTICATE PLAIN", "AUTHENTICATE "+plain("k1abc", "hunter2"),
+		"CAP END", "NICK ham", "USER K1ABC-7 0 * :Ham")
+	expect(t, conn, r, "sasl")
+	expect(t, conn, r, "AUTHENTICATE +")
+	expect(t, conn, r, ERR_SASLFAIL)
+	expect(t, conn, r, RPL_LOGGEDIN+" * * K1ABC :You are now logged in as K1ABC")
+	expect(t, conn, r, RPL_SASLSUCCESS)
+	expect(t, conn, r, RPL_WELCOME)
+
+	user := s.Nick("ham")
+	if user == nil || !user.authenticated() || s.txDenied(user) != "" {
+		t.Fatalf("user after SASL: %+v, txDenied %q", user, s.txDenied(user))
+	}
+}
+
+func TestAuthPolicy(t *testing.T) {
+	s := NewServer()
+	s.Credentials = Credentials{"K1ABC": HashPassword("hunter2")}
+
+	// a callsign with credentials only transmits once logged in
+	conn, r := testClient(t, s, "NICK ham", "USER K1ABC 0 * :Ham")
+	expect(t, conn, r, "receive-only (you have not logged in as K1ABC)")
+
+	s.AuthPolicy = AuthReceiveOnly
+	conn, r = testClient(t, s, "NICK other", "USER W1AW 0 * :Hiram")
+	expect(t, conn, r, "receive-only (you have not logged in)")
+
+	s.AuthPolicy = AuthRefuse
+	conn, r = testClient(t, s, "NICK third", "USER N0CALL 0 * :Nobody")
+	expect(t, conn, r, "ERROR :Closing link: authentication required")
+
+	conn, r = testClient(t, s, "PASS hunter2", "NICK fourth", "USER W1AW 0 * :Hiram")
+	expect(t, conn, r, "ERROR :Closing link: bad password")
+}
+
+// testCerts makes a CA and a client certificate it issued, the way
+// Logbook of The World does, with the callsign in an extension.
+func testCerts(t *testing.T, call string) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, _ := asn1.Marshal(call)
+	leaf := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "Hiram Percy Maxim"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: oidCallsign, Value: value}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertificate(t *testing.T) {
+	s := NewServer()
+	s.AuthPolicy = AuthRefuse
+	pool, cert := testCerts(t, "K1ABC")
+	// the server's own certificate goes unchecked
+	_, serverCert := testCerts(t, "W1AW")
+
+	connect := func(lines ...string) (net.Conn, *bufio.Reader) {
+		conn, serverConn := net.Pipe()
+		go s.handleConnection(tls.Server(serverConn, &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		}))
+		client := tls.Client(conn, &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		})
+		go func() {
+			for _, line := range lines {
+				fmt.Fprintf(client, "%s\r\n", line)
+			}
+		}()
+		return client, bufio.NewReader(client)
+	}
+
+	conn, r := connect("NICK ham", "USER W1AW 0 * :Hiram")
+	expect(t, conn, r, ERR_INVALIDUSERNAME+" ham W1AW :Your certificate is for K1ABC")
+
+	conn, r = connect("NICK ham", "USER K1ABC-9 0 * :Ham")
+	expect(t, conn, r, RPL_WELCOME)
+	user := s.Nick("ham")
+	if user == nil || !user.authenticated() || s.txDenied(user) != "" {
+		t.Fatalf("user with certificate: %+v, txDenied %q", user, s.txDenied(user))
+	}
+}
@@ -2,18 +2,19 @@ package irc
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
-	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/sparques/hamirc/ax25"
 	"github.com/sparques/hamirc/kiss"
 	"go.bug.st/serial"
 )
@@ -41,9 +42,112 @@ type Server struct {
 	// get messages for.
 	AutoJoin bool
 	Debug    bool
-	exitch   chan error
-	tnc      *kiss.TNC
-	tncport  int
+	// Legacy causes bare-text frames, as sent by hamirc before it used
+	// AX.25, to be accepted alongside AX.25 UI frames.
+	Legacy bool `json:"-"`
+	// Path is the digipeater path put on transmitted frames.
+	Path []ax25.Address `json:"-"`
+	// TNCParams are sent to the TNC whenever it is connected.
+	TNCParams kiss.Params `json:"-"`
+	// MTU is the longest line sent in one frame; longer messages are
+	// fragmented. Fragments of received messages are held for up to
+	// FragmentTimeout.
+	MTU             int           `json:"-"`
+	FragmentTimeout time.Duration `json:"-"`
+	// DedupWindow is how long a received line is remembered so that
+	// repeated copies of it are dropped. Zero disables the check.
+	DedupWindow time.Duration `json:"-"`
+	// AckRetries, if above zero, asks remote stations to acknowledge
+	// private messages, retransmitting up to AckRetries times. The first
+	// retransmission is after AckTimeout.
+	AckRetries int           `json:"-"`
+	AckTimeout time.Duration `json:"-"`
+	// IDCall is the station callsign. If set, station identification
+	// is transmitted every IDInterval while the station is
+	// transmitting and at the end of each transmission session.
+	IDCall     string        `json:"-"`
+	IDInterval time.Duration `json:"-"`
+	// LocalCallsigns is applied to local users whose USER ident is not
+	// a plausible callsign, RemoteCallsigns to stations heard over the
+	// radio.
+	LocalCallsigns  CallsignPolicy `json:"-"`
+	RemoteCallsigns CallsignPolicy `json:"-"`
+	// ReceiveOnly keeps the whole station off the air; local users only
+	// monitor.
+	ReceiveOnly bool `json:"-"`
+	// TransmitAllow, if not empty, lists the only base callsigns local
+	// users may transmit as. See SetTransmitAllow.
+	TransmitAllow []string `json:"-"`
+	// Opers lists the base callsigns of local users who may manage Bans
+	// once logged in. See SetOpers.
+	Opers []string `json:"-"`
+	// Bans drop radio traffic from matching stations.
+	Bans []*Ban
+	// Silences holds the masks each local callsign has silenced with
+	// the SILENCE command, as bans with no channel.
+	Silences map[string][]*Ban `json:",omitempty"`
+	// Bouncer keeps local users on the server while no client is
+	// connected, buffering up to BacklogLines lines for them. Clients
+	// authenticate with PASS, checked against Credentials by callsign.
+	Bouncer      bool        `json:"-"`
+	BacklogLines int         `json:"-"`
+	Credentials  Credentials `json:"-"`
+	AuthPolicy   AuthPolicy  `json:"-"`
+	// Local clients are sent a PING every PingInterval and dropped if
+	// they leave one unanswered for PingTimeout without sending anything
+	// else.
+	PingInterval time.Duration `json:"-"`
+	PingTimeout  time.Duration `json:"-"`
+	// SendQ is how many lines may be queued for a client before it is
+	// disconnected for not keeping up.
+	SendQ int `json:"-"`
+	// History stores messages for CHATHISTORY if set. ReplayLines is
+	// how many of a channel's last messages local users get on JOIN.
+	History     *History `json:"-"`
+	ReplayLines int      `json:"-"`
+	// Bitrate is the radio's bit rate. If set, the airtime each frame
+	// takes is charged against a station-wide budget of DutyCycle and a
+	// budget of UserDutyCycle for each callsign, each of which may burst
+	// up to AirtimeBurst. Messages over budget are held back for up to
+	// AirtimeMaxWait and refused beyond that.
+	Bitrate        int           `json:"-"`
+	DutyCycle      float64       `json:"-"`
+	UserDutyCycle  float64       `json:"-"`
+	AirtimeBurst   time.Duration `json:"-"`
+	AirtimeMaxWait time.Duration `json:"-"`
+	// Presence transmits PART, QUIT and NICK for local users, so other
+	// stations can keep track of them.
+	Presence bool `json:"-"`
+	// Remote users not heard for UserExpiry are parted from their
+	// channels and forgotten. Channels without messages for
+	// ChannelExpiry drop out of LIST, and are forgotten once empty.
+	// Zero turns either off.
+	UserExpiry    time.Duration `json:"-"`
+	ChannelExpiry time.Duration `json:"-"`
+	// CoalesceWindow is how long short lines are held so that more lines
+	// from the same user to the same target can share their frame.
+	CoalesceWindow time.Duration `json:"-"`
+	// Messages transmitted for callsigns in SigningKeys are signed, and
+	// messages heard from callsigns in Keyring are checked.
+	SigningKeys SigningKeys `json:"-"`
+	Keyring     Keyring     `json:"-"`
+
+	exitch    chan error
+	sessions  map[string]*User
+	clients   map[*client]struct{}
+	link      *tncLink
+	ider      ider
+	fragments reassembler
+	dedup     dedupCache
+	// sigReplays remembers the signatures heard lately, to catch replays.
+	sigReplays dedupCache
+	acks       ackTracker
+	stats      stats
+	airtime    airtimeBudget
+	txq        *txQueue
+
+	// clientCerts is set when TLS clients may present certificates.
+	clientCerts bool
 }
 
 func NewServer() *Server {
@@ -53,6 +157,26 @@ func NewServer() *Server {
 		Users:    make(UserMap),
 		Channels: make(map[string]*Channel),
 		exitch:   make(chan error),
+		sessions: make(map[string]*User),
+		clients:  make(map[*client]struct{}),
+		txq:      newTXQueue(),
+
+		MTU:             DefaultMTU,
+		FragmentTimeout: DefaultFragmentTimeout,
+		DedupWindow:     DefaultDedupWindow,
+		AckTimeout:      DefaultAckTimeout,
+		BacklogLines:    DefaultBacklogLines,
+		SendQ:           DefaultSendQ,
+		PingInterval:    DefaultPingInterval,
+		PingTimeout:     DefaultPingTimeout,
+		DutyCycle:       DefaultDutyCycle,
+		UserDutyCycle:   DefaultUserDutyCycle,
+		AirtimeBurst:    DefaultAirtimeBurst,
+		AirtimeMaxWait:  DefaultAirtimeMaxWait,
+		CoalesceWindow:  DefaultCoalesceWindow,
+		Presence:        true,
+		UserExpiry:      DefaultUserExpiry,
+		ChannelExpiry:   DefaultChannelExpiry,
 	}
 }
 
@@ -62,34 +186,59 @@ func (s *Server) Nick(nick string) *User {
 	return s.Users[nickKey(nick)]
 }
 
+// Serve accepts IRC connections on listenAddr, as well as on any
+// listener started by ListenTLS, and runs the server until Exit is
+// called. An empty listenAddr serves TLS only.
 func (s *Server) Serve(listenAddr string) error {
-	listener, err := net.Listen("tcp", listenAddr)
-	if err != nil {
-		return err
+	if listenAddr != "" {
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		log.Printf("%s server started. Listening on %s", s.Name, listenAddr)
+		go s.accept(listener)
 	}
-	defer listener.Close()
 
-	log.Printf("%s server started. Listening on %s", s.Name, listenAddr)
 	go s.handleTNC()
+	if s.IDCall != "" && s.link != nil {
+		go s.identify()
+	}
 
 	go s.PingPong()
+	go s.expire()
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				if strings.HasSuffix(err.Error(), "use of closed network connection") {
-					return
-				}
-				log.Printf("Error accepting connection: %v\n", err)
-				continue
+	return <-s.exitch
+}
+
+// ListenTLS starts accepting IRC connections over TLS on listenAddr.
+// If config has ClientCAs, clients may present a certificate issued for
+// their callsign, which logs them in as it; config.ClientAuth decides
+// whether they must.
+func (s *Server) ListenTLS(listenAddr string, config *tls.Config) error {
+	listener, err := tls.Listen("tcp", listenAddr, config)
+	if err != nil {
+		return err
+	}
+	s.clientCerts = config.ClientCAs != nil
+	log.Printf("%s server listening for TLS on %s", s.Name, listenAddr)
+	go s.accept(listener)
+	return nil
+}
+
+func (s *Server) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
 			}
-			log.Printf("New connection on %s\n", conn.RemoteAddr())
-			go s.handleConnection(conn)
+			log.Printf("Error accepting connection: %v\n", err)
+			continue
 		}
-	}()
-
-	return <-s.exitch
+		log.Printf("New connection on %s\n", conn.RemoteAddr())
+		go s.handleConnection(conn)
+	}
 }
 
 func (s *Server) Exit(err error) {
@@ -153,7 +302,8 @@ func parse(line string) []string {
 	return args
 }
 
-// ConnectTNC connects to a TNC via tcp or serial.
+// ConnectTNC connects to a TNC via tcp or serial. If the connection is
+// later lost, it is redialed until it comes back.
 func (s *Server) ConnectTNC(addr string, tncport int) (err error) {
 	serialAddr, baud, isSerial, err := parseSerialTNCAddress(addr)
 	if err != nil {
@@ -163,26 +313,60 @@ func (s *Server) ConnectTNC(addr string, tncport int) (err error) {
 		mode := &serial.Mode{
 			BaudRate: baud,
 		}
+		dial := func() (io.ReadWriteCloser, error) {
+			return serial.Open(serialAddr, mode)
+		}
 
-		port, err := serial.Open(serialAddr, mode)
+		port, err := dial()
 		if err != nil {
 			return fmt.Errorf("could not open serial port for kiss tnc: %w", err)
 		}
 		log.Printf("Connected to TNC port %d at %s, %d baud", tncport, serialAddr, mode.BaudRate)
-		s.tnc = kiss.NewTNC(port)
-		s.tncport = tncport
+		s.startLink(serialAddr, tncport, dial, port)
 		return nil
 	}
-	conn, err := net.Dial("tcp", addr)
+	dial := func() (io.ReadWriteCloser, error) {
+		return net.Dial("tcp", addr)
+	}
+	conn, err := dial()
 	if err != nil {
 		return fmt.Errorf("could not connect to kiss tnc: %w", err)
 	}
 	log.Printf("Connected to TNC port %d at %s", tncport, addr)
-	s.tnc = kiss.NewTNC(conn)
-	s.tncport = tncport
+	s.startLink(addr, tncport, dial, conn)
 	return nil
 }
 
+func (s *Server) startLink(addr string, tncport int, dial func() (io.ReadWriteCloser, error), conn io.ReadWriteCloser) {
+	s.link = newTNCLink(addr, uint8(tncport), s.TNCParams, dial)
+	s.link.onState = s.linkState
+	s.link.start(conn)
+	go s.transmitter()
+}
+
+// linkState lets local users know when the radio link goes down and
+// comes back.
+func (s *Server) linkState(up bool) {
+	if up {
+		s.noticeLocal("Radio link is up.")
+	} else {
+		s.noticeLocal("Radio link to the TNC was lost; outgoing messages will be queued until it comes back.")
+	}
+}
+
+// ExitKISS takes the TNC out of KISS mode. Hardware TNCs need this to
+// get back to their command prompt.
+func (s *Server) ExitKISS() error {
+	if s.link == nil {
+		return nil
+	}
+	port := s.link.Port()
+	if port == nil {
+		return errors.New("TNC is not connected")
+	}
+	return port.ExitKISS()
+}
+
 func parseSerialTNCAddress(addr string) (port string, baud int, ok bool, err error) {
 	baud = defaultTNCBaud
 	addr = strings.TrimSpace(addr)
@@ -258,133 +442,74 @@ func allDigits(s string) bool {
 // real hardware serial port TNC, or direwolf's pty interface to its
 // kiss TNC.
 func (s *Server) OpenTNC(path string) error {
-	fh, err := os.OpenFile(path, os.O_RDWR, 0600)
+	dial := func() (io.ReadWriteCloser, error) {
+		return os.OpenFile(path, os.O_RDWR, 0600)
+	}
+	fh, err := dial()
 	if err != nil {
 		return fmt.Errorf("could not open %s kiss tnc: %w", path, err)
 	}
-	s.tnc = kiss.NewTNC(fh)
+	s.startLink(path, 0, dial, fh)
 	return nil
 }
 
-func (s *Server) handleTNC() {
-	defer s.Exit(errors.New("lost connection to TNC"))
-	// Just use port zero
-	port := s.tnc.Port(uint8(s.tncport))
-	// send our out going
-	// read incoming messages
-	buf := make([]byte, 1024*512)
-	for {
-		buf = buf[0:1024]
-		n, err := port.Read(buf)
-		if err != nil {
-			log.Printf("error reading from TNC port: %s", err)
-			return
-		}
-		// TODO: error handling.
-		buf = buf[:n]
-
-		// replace \n just in case...
-		args := parse(strings.ReplaceAll(string(buf), "\n", " "))
-
-		s.debugf("<TNC> %v", args)
-
-		if len(args) < 3 {
-			continue
-		}
-
-		// only let PRIVMSG, NOTICE, and topic through
-		if !slices.Contains([]string{"PRIVMSG", "NOTICE", "TOPIC"}, args[1]) {
-			continue
-		}
-
-		// track seen users
-		incomingUser := NewUser("", io.Discard)
-		incomingUser.Parse(args[0])
-
-		if incomingUser.Nick == "" {
-			continue
-		}
-		// add user to server if not previously seen
-		if existingUser := s.Nick(incomingUser.Nick); existingUser == nil {
-			s.Lock()
-			if existingUser = s.Users[nickKey(incomingUser.Nick)]; existingUser == nil {
-				s.Users[nickKey(incomingUser.Nick)] = incomingUser
-			} else {
-				incomingUser = existingUser
-			}
-			s.Unlock()
-		} else {
-			incomingUser = existingUser
-		}
-
-		// do user-level ban check here?
-
-		// if target is channel
-		if strings.HasPrefix(args[2], "#") {
-			// create channel if it doesn't exist
-			ch := s.Channel(args[2])
-
-			// add user to channel if not already there
-			if nil == ch.Nick(incomingUser.Nick) {
-				s.joinChannel(incomingUser, ch.Name)
-			}
-
-			if s.AutoJoin {
-				var usersToJoin []*User
-				s.Lock()
-				for _, u := range s.Users {
-					_, ok := ch.Users[nickKey(u.Nick)]
-					if u.Local() && !ok {
-						usersToJoin = append(usersToJoin, u)
-					}
-				}
-				s.Unlock()
-				for _, u := range usersToJoin {
-					s.joinChannel(u, args[2])
-				}
-			}
-		}
-
-		if args[1] == "TOPIC" {
-			s.setTopic(incomingUser, s.Channel(args[2]), strings.Join(args[3:], " "))
-		} else {
-			if len(args) < 4 {
-				continue
-			}
-			s.send(incomingUser, args[1], args[2], args[3])
-		}
-	}
-
-}
-
 // handleConnection handles an incoming connection
 func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
-
 	scanner := bufio.NewScanner(conn)
 
-	var hijack = struct {
-		io.Writer
-		io.Reader
-	}{
-		// Writer: io.MultiWriter(conn, os.Stdout),
-		Writer: io.MultiWriter(conn),
-		Reader: conn,
-	}
-
-	user := NewUser("", hijack)
+	c := newClient(conn, s.SendQ)
+	defer c.close()
+	user := NewUser("", nil)
 	user.local = true
-	user.conn = conn
+	user.attach(c)
+	s.Lock()
+	s.clients[c] = struct{}{}
+	s.Unlock()
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		call, err := s.handshake(tlsConn)
+		if err != nil {
+			log.Printf("<%s> TLS handshake failed: %s", conn.RemoteAddr(), err)
+			fmt.Fprintf(c, "ERROR :Closing link: %s\r\n", err)
+			s.disconnect(c)
+			return
+		}
+		user.cert = call
+	}
 
 	// Handle commands
 	for scanner.Scan() {
-		if s.handleCommand(user, strings.TrimSpace(scanner.Text())) {
-			s.removeUser(user)
+		if s.handleCommand(c, strings.TrimSpace(scanner.Text())) {
+			s.disconnect(c)
 			return
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		log.Printf("<%s@%s> Disconnected: %s\n", user.ID(), conn.RemoteAddr(), err)
+		log.Printf("<%s@%s> Disconnected: %s\n", c.user.ID(), conn.RemoteAddr(), err)
+	}
+	s.disconnect(c)
+}
+
+// disconnect cleans up after a client connection goes away. Bouncer
+// sessions stay behind; anyone else is removed from the server.
+func (s *Server) disconnect(c *client) {
+	s.Lock()
+	delete(s.clients, c)
+	s.Unlock()
+
+	user := c.user
+	remaining := user.detach(c)
+	if user.session {
+		if remaining == 0 {
+			log.Printf("Session %s detached.", user.ID())
+		}
+		return
+	}
+	c.mu.Lock()
+	reason := c.quitReason
+	c.mu.Unlock()
+	if reason != "" && user.registered {
+		s.quit(user, reason)
 	}
 	s.removeUser(user)
 }
@@ -393,27 +518,86 @@ func (s *Server) removeUser(user *User) {
 	s.Lock()
 	defer s.Unlock()
 
-	delete(s.Users, nickKey(user.Nick))
+	if s.Users[nickKey(user.Nick)] == user {
+		delete(s.Users, nickKey(user.Nick))
+	}
 	for _, ch := range s.Channels {
 		// need to add quit message
-		delete(ch.Users, nickKey(user.Nick))
+		if ch.Users[nickKey(user.Nick)] == user {
+			delete(ch.Users, nickKey(user.Nick))
+		}
 	}
 }
 
-func (s *Server) acceptUser(user *User) {
-	if user.Nick == "" {
+// acceptUser completes registration once a client has given both NICK
+// and USER. It returns true if the connection should be closed.
+func (s *Server) acceptUser(user *User) (quit bool) {
+	if user.Nick == "" || user.registered || user.negotiating() {
 		return
 	}
+
+	if !s.checkAuth(user) {
+		return true
+	}
+	if s.Bouncer {
+		if session := s.session(user.account); session != nil {
+			s.reattach(user, session)
+			return
+		}
+	}
+
+	s.Lock()
+	if existing, ok := s.Users[nickKey(user.Nick)]; ok && existing.Local() {
+		s.Unlock()
+		// let the client pick another nick and try again
+		s.reply(user, ERR_NICKNAMEINUSE, replyNick(user), user.Nick, "Nickname is already in use")
+		user.Nick = ""
+		return
+	}
+	user.registered = true
+	s.Users[nickKey(user.Nick)] = user
+	if s.Bouncer {
+		user.session = true
+		user.backlogMax = s.BacklogLines
+		s.sessions[baseCallsign(user.Callsign)] = user
+	}
+	s.Unlock()
+
+	log.Printf("Accepted user %s.\n", user.ID())
+	s.welcome(user)
+	return
+}
+
+func (s *Server) welcome(user *User) {
 	s.reply(user, RPL_WELCOME, user.Nick, "Connected.")
 	s.reply(user, RPL_YOURHOST, user.Nick, fmt.Sprintf("Your host is %s.", s.Name))
 	s.reply(user, RPL_CREATED, user.Nick, "Server is ready.")
+	isupport := []string{RPL_ISUPPORT, user.Nick, fmt.Sprintf("SILENCE=%d", silenceMax)}
+	if s.History != nil {
+		isupport = append(isupport, fmt.Sprintf("CHATHISTORY=%d", historyMaxLimit))
+	}
+	s.reply(user, append(isupport, "are supported by this server")...)
 
-	log.Printf("Accepted user %s.\n", user.ID())
+	s.motd(user)
+	if reason := s.txDenied(user); reason != "" {
+		s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("This session is receive-only (%s): you will see radio traffic, but cannot transmit.", reason))
+	}
+}
+
+// noticeLocal sends a server NOTICE to every local user.
+func (s *Server) noticeLocal(msg string) {
 	s.Lock()
-	s.Users[nickKey(user.Nick)] = user
+	var users []*User
+	for _, u := range s.Users {
+		if u.Local() {
+			users = append(users, u)
+		}
+	}
 	s.Unlock()
 
-	s.motd(user)
+	for _, u := range users {
+		s.reply(u, "NOTICE", u.Nick, msg)
+	}
 }
 
 func (s *Server) motd(user *User) {
@@ -427,41 +611,40 @@ func (s *Server) motd(user *User) {
 	s.reply(user, RPL_ENDOFMOTD, user.Nick, "End of /MOTD command.")
 }
 
-func (s *Server) PingPong() {
-	for {
-		time.Sleep(2 * time.Minute)
-		s.Lock()
-		for _, user := range s.Users {
-			if user.Local() {
-				fmt.Fprintf(user, "PING :LAG%d\r\n", time.Now().Unix())
-			}
-		}
-		s.Unlock()
-	}
-}
-
 // handleCommand processes IRC commands
-func (s *Server) handleCommand(user *User, line string) (quit bool) {
+func (s *Server) handleCommand(c *client, line string) (quit bool) {
+	_, line = splitTags(line)
 	args := parse(line)
 	if len(args) == 0 {
 		return
 	}
 	command := strings.ToUpper(args[0])
+	user := c.user
+	// tags sent by clients with message-tags are accepted and ignored
 
-	remoteAddr := "<unknown>"
-	if user.conn != nil {
-		remoteAddr = user.conn.RemoteAddr().String()
-	}
-	s.debugf("<%s@%s> %s", user.Nick, remoteAddr, args)
-	if user.Nick == "" {
+	s.debugf("<%s@%s> %s", user.Nick, c.remoteAddr(), args)
+	c.seen(time.Now(), command == "PONG")
+	if !user.registered {
 		switch command {
-		case "NICK", "USER", "CAP":
+		case "NICK", "USER", "CAP", "PASS", "AUTHENTICATE", "PING", "PONG", "QUIT":
 		default:
 			s.reply(user, ERR_NOTREGISTERED, replyNick(user), command, "You have not registered")
 			return
 		}
 	}
 
+	if command == "CAP" {
+		// capabilities belong to the connection, not the user
+		return s.capCmd(c, args)
+	}
+	if command == "AUTHENTICATE" {
+		// SASL state belongs to the connection
+		return s.authenticate(c, args)
+	}
+	if command == "CHATHISTORY" {
+		// history goes to the connection that asked, not the whole session
+		return s.chathistory(c, args)
+	}
 	if cmdFunc, ok := cmdSet[command]; ok {
 		return cmdFunc(s, user, args)
 	} else {
@@ -505,8 +688,6 @@ func (s *Server) changeNick(user *User, newNick string) {
 	if oldNickLower != "" {
 		delete(s.Users, oldNickLower)
 		s.Users[newNickLower] = user
-	} else if user.Callsign != "" {
-		s.Users[newNickLower] = user
 	}
 
 	for _, ch := range s.Channels {
@@ -527,6 +708,7 @@ func (s *Server) changeNick(user *User, newNick string) {
 	for _, recipient := range uniqueUsers(recipients) {
 		fmt.Fprintf(recipient, ":%s NICK :%s\r\n", oldNick, newNick)
 	}
+	s.transmitPresence(user, "NICK", newNick, "")
 }
 
 func uniqueUsers(users []*User) []*User {
@@ -543,63 +725,91 @@ func uniqueUsers(users []*User) []*User {
 }
 
 func (s *Server) listUsers(user *User, mask string) {
-	s.Lock()
-	defer s.Unlock()
-
 	// who response:Is there
 	// 352 <channel> <user> <host> <server> <nick> <status> :<hopcount> <realname>
+	var lines []string
 
+	s.Lock()
 	switch {
 	case strings.HasPrefix(mask, "#"):
 		if ch, ok := s.Channels[channelKey(mask)]; ok {
 			for _, u := range ch.Users {
-				fmt.Fprintf(user, ":%s 352 %s %s %s * * %s %s :1 %s\r\n", s.Name, user.Nick, ch.Name, u.Callsign, u.Nick, u.Status(), u.RealName)
+				lines = append(lines, fmt.Sprintf(":%s 352 %s %s %s * * %s %s :1 %s\r\n", s.Name, user.Nick, ch.Name, u.Callsign, u.Nick, u.Status(), u.RealName))
 			}
 		}
 	case mask == "*":
 		s.debugf("Listing all users for %s", user.Nick)
 		for _, u := range s.Users {
-			fmt.Fprintf(user, ":%s 352 %s * %s * * %s %s :1 %s\r\n", s.Name, user.Nick, u.Callsign, u.Nick, u.Status(), u.RealName)
+			lines = append(lines, fmt.Sprintf(":%s 352 %s * %s * * %s %s :1 %s\r\n", s.Name, user.Nick, u.Callsign, u.Nick, u.Status(), u.RealName))
 		}
 	default:
 		// treat as user
 		for _, u := range s.Users {
 			if u.ID() == mask || u.Nick == mask {
 				// server caller channel user host server nick status :hopcount realname
-				fmt.Fprintf(user, ":%s 352 %s * %s * * %s %s :1 %s\r\n", s.Name, user.Nick, u.Callsign, u.Nick, u.Status(), u.RealName)
-				return
+				lines = append(lines, fmt.Sprintf(":%s 352 %s * %s * * %s %s :1 %s\r\n", s.Name, user.Nick, u.Callsign, u.Nick, u.Status(), u.RealName))
+				break
 			}
 		}
 	}
+	s.Unlock()
+
+	for _, line := range lines {
+		fmt.Fprint(user, line)
+	}
 }
 
 func (s *Server) send(sender *User, cmd, target, msg string) {
+	s.sendTagged(sender, time.Now(), nil, cmd, target, msg)
+}
+
+// sendTagged is send for a message sent or heard at, with extra tags
+// for the IRC clients that receive it. Each client only gets the tags
+// it negotiated.
+func (s *Server) sendTagged(sender *User, at time.Time, tags wireTags, cmd, target, msg string) {
+	transmit := sender.Local()
+	if reason := s.txDenied(sender); transmit && reason != "" {
+		if cmd == "PRIVMSG" || cmd == "NOTICE" {
+			s.reply(sender, ERR_CANNOTSENDTOCHAN, sender.Nick, target, "Cannot send: "+reason)
+			return
+		}
+		// still let PART and QUIT happen locally
+		transmit = false
+	}
+
 	s.Lock()
 
 	// update LastSeen
 	sender.LastSeen = time.Now()
 	senderID := sender.ID()
 
-	var tncWriter io.Writer
-	if sender.Local() && s.tnc != nil {
-		tncWriter = s.tnc.Port(uint8(s.tncport))
-	}
-
 	var recipients []*User
+	var ackTarget *User
+	var targetAway string
 	if strings.HasPrefix(target, "#") {
 		ch, ok := s.Channels[channelKey(target)]
 		if !ok {
 			s.Unlock()
 			return
 		}
+		if cmd == "PRIVMSG" || cmd == "NOTICE" {
+			ch.LastSeen = sender.LastSeen
+		}
 		for _, u := range ch.Users {
 			if u.Nick == sender.Nick && cmd != "PART" {
 				continue
 			}
+			if (cmd == "PRIVMSG" || cmd == "NOTICE") && s.silenced(u, sender, target, msg) {
+				continue
+			}
 			recipients = append(recipients, u)
 		}
 	} else if targetUser, ok := s.Users[nickKey(target)]; ok {
-		recipients = append(recipients, targetUser)
+		if !s.silenced(targetUser, sender, target, msg) {
+			recipients = append(recipients, targetUser)
+		}
+		ackTarget = targetUser
+		targetAway = targetUser.away
 	} else {
 		s.Unlock()
 		return
@@ -607,12 +817,31 @@ func (s *Server) send(sender *User, cmd, target, msg string) {
 	s.Unlock()
 
 	// Transmit local messages via radio after releasing the server lock.
-	if tncWriter != nil {
-		fmt.Fprintf(tncWriter, ":%s %s %s :%s", senderID, cmd, target, msg)
+	// Whether that worked is told to the sender by NOTICE.
+	switch {
+	case !transmit:
+	case presence(cmd) && !s.Presence:
+	case ackTarget != nil && !ackTarget.Local() && s.AckRetries > 0 && (cmd == "PRIVMSG" || cmd == "NOTICE"):
+		s.transmitAcked(sender, cmd, target, msg)
+	default:
+		s.transmit(sender, nil, cmd, target, msg)
 	}
 
+	s.record(at, sender, tags, cmd, target, msg)
+
+	clientTags := wireTags{timeTag: serverTime(at)}
+	for k, v := range tags {
+		clientTags[k] = v
+	}
+	line := fmt.Sprintf("%s:%s %s %s :%s\r\n", clientTags, senderID, cmd, target, msg)
 	for _, recipient := range recipients {
-		fmt.Fprintf(recipient, ":%s %s %s :%s\r\n", senderID, cmd, target, msg)
+		fmt.Fprint(recipient, line)
+	}
+	if sender.Local() && (cmd == "PRIVMSG" || cmd == "NOTICE") {
+		sender.echo([]byte(line))
+	}
+	if targetAway != "" && cmd == "PRIVMSG" && sender.Local() {
+		s.reply(sender, RPL_AWAY, sender.Nick, target, targetAway)
 	}
 }
 
@@ -642,92 +871,107 @@ func (s *Server) joinChannel(user *User, channelName string) {
 		names = append(names, u.Nick)
 	}
 	topic := channel.Topic
+	away := user.away
 	s.Unlock()
 
 	userID := user.ID()
+	tags := wireTags{timeTag: serverTime(time.Now())}
 	for _, recipient := range recipients {
-		fmt.Fprintf(recipient, ":%s JOIN :%s\r\n", userID, channelName)
+		fmt.Fprintf(recipient, "%s:%s JOIN :%s\r\n", tags, userID, channelName)
+		if away != "" {
+			// dropped for clients without away-notify
+			fmt.Fprintf(recipient, ":%s AWAY :%s\r\n", userID, away)
+		}
 	}
 
+	s.channelInfo(user, channelName, topic, names)
+	if user.Local() {
+		s.replayHistory(user, channelName)
+	}
+}
+
+// namesLineMax keeps RPL_NAMREPLY lines within the 512 byte IRC limit.
+const namesLineMax = 400
+
+// channelInfo sends the topic and names list that follow a JOIN.
+func (s *Server) channelInfo(user *User, channelName, topic string, names []string) {
 	if topic == "" {
 		s.reply(user, RPL_NOTOPIC, user.Nick, channelName, "No topic is set")
 	} else {
 		s.reply(user, RPL_TOPIC, user.Nick, channelName, topic)
 	}
 
-	fmt.Fprintf(user, ":%s 353 %s = %s :", s.Name, user.Nick, channelName)
-
-	for _, name := range names {
-		fmt.Fprintf(user, "%s ", name)
+	// each 353 is written whole, so lines for the user from elsewhere
+	// cannot end up in the middle of it
+	prefix := fmt.Sprintf(":%s 353 %s = %s :", s.Name, user.Nick, channelName)
+	for len(names) > 0 {
+		n := 0
+		for size := len(prefix); n < len(names) && (n == 0 || size+len(names[n]) < namesLineMax); n++ {
+			size += len(names[n]) + 1
+		}
+		fmt.Fprintf(user, "%s%s\r\n", prefix, strings.Join(names[:n], " "))
+		names = names[n:]
 	}
-	fmt.Fprintf(user, "\r\n")
 	s.reply(user, RPL_ENDOFNAMES, user.Nick, channelName, "End of /NAMES list")
 }
 
 func (s *Server) userHost(user *User, nicks []string) {
-	s.Lock()
-	defer s.Unlock()
-
 	//:irc.example.com 302 Sparques :Nick1=-user1@host1 Nick2=+user2@host2
-	fmt.Fprintf(user, ":%s 302 %s :", s.Name, user.Nick)
-
+	var replies []string
+	s.Lock()
 	for _, nick := range nicks {
 		u, ok := s.Users[nickKey(nick)]
 		if !ok {
 			continue
 		}
-		fmt.Fprintf(user, "%s=-%s@%s ", nick, u.Callsign, strings.ReplaceAll(u.RealName, " ", "_"))
-	}
-	fmt.Fprintf(user, "\r\n")
-}
-
-func (s *Server) quit(user *User, reason string) {
-	s.Lock()
-	channels := make([]string, 0, len(s.Channels))
-	for _, ch := range s.Channels {
-		if _, ok := ch.Users[nickKey(user.Nick)]; ok {
-			channels = append(channels, ch.Name)
-		}
+		replies = append(replies, fmt.Sprintf("%s=-%s@%s", nick, u.Callsign, strings.ReplaceAll(u.RealName, " ", "_")))
 	}
 	s.Unlock()
 
-	for _, channel := range channels {
-		s.send(user, "QUIT", channel, reason)
-	}
+	fmt.Fprintf(user, ":%s 302 %s :%s\r\n", s.Name, user.Nick, strings.Join(replies, " "))
 }
 
 func (s *Server) topic(user *User, channel string) {
-	s.Lock()
-	defer s.Unlock()
 	// TODO: Figure out a way to share topics
 	// When topic is set, might have to broadcast out something like
 	// :<user.ID()> TOPIC <channel> <topic>
+	s.Lock()
 	ch, ok := s.Channels[channelKey(channel)]
 	if !ok {
+		s.Unlock()
 		s.reply(user, ERR_NOSUCHCHANNEL, user.Nick, channel, "no such channel")
 		return
 	}
+	name, topic, who, when := ch.Name, ch.Topic, ch.TopicWho, ch.TopicTime
+	s.Unlock()
 
-	if ch.Topic == "" {
-		s.reply(user, RPL_NOTOPIC, user.Nick, ch.Name, "No topic is set")
+	if topic == "" {
+		s.reply(user, RPL_NOTOPIC, user.Nick, name, "No topic is set")
 	} else {
-		s.reply(user, RPL_TOPIC, user.Nick, ch.Name, ch.Topic)
-		s.reply(user, RPL_TOPICWHOTIME, user.Nick, ch.Name, ch.TopicWho, strconv.Itoa(int(ch.TopicTime.Unix())))
+		s.reply(user, RPL_TOPIC, user.Nick, name, topic)
+		s.reply(user, RPL_TOPICWHOTIME, user.Nick, name, who, strconv.Itoa(int(when.Unix())))
 	}
 }
 
 func (s *Server) listChannels(user *User) {
+	type listEntry struct {
+		name, users, topic string
+	}
 	s.Lock()
-	channels := make([]*Channel, 0, len(s.Channels))
+	channels := make([]listEntry, 0, len(s.Channels))
+	now := time.Now()
 	for _, ch := range s.Channels {
-		channels = append(channels, ch)
+		if s.channelIdle(ch, now) {
+			continue
+		}
+		channels = append(channels, listEntry{ch.Name, strconv.Itoa(len(ch.Users)), ch.Topic})
 	}
 	s.Unlock()
 
 	// LIST filters are intentionally ignored for now.
 	s.reply(user, RPL_LISTSTART, user.Nick, "Channel", "Users Name")
 	for _, ch := range channels {
-		s.reply(user, RPL_LIST, user.Nick, ch.Name, strconv.Itoa(len(ch.Users)), ch.Topic)
+		s.reply(user, RPL_LIST, user.Nick, ch.name, ch.users, ch.topic)
 	}
 	s.reply(user, RPL_LISTEND, user.Nick, "End of /LIST")
 }
@@ -740,6 +984,18 @@ func (s *Server) whois(user *User, nickList string) {
 			continue
 		}
 		s.reply(user, RPL_WHOISUSER, user.Nick, u.Nick, u.Callsign, "*", u.RealName)
+		if lag, ok := u.lag(); ok {
+			s.reply(user, RPL_WHOISSPECIAL, user.Nick, u.Nick, fmt.Sprintf("has a lag of %s", lag.Round(time.Millisecond)))
+		}
+		s.Lock()
+		away := u.away
+		s.Unlock()
+		if away != "" {
+			s.reply(user, RPL_AWAY, user.Nick, u.Nick, away)
+		}
+		if s.isOper(u) {
+			s.reply(user, RPL_WHOISOPERATOR, user.Nick, u.Nick, "is a server operator")
+		}
 	}
 	s.reply(user, RPL_ENDOFWHOIS, user.Nick, nickList, "End of /WHOIS list")
 }
@@ -748,22 +1004,22 @@ func (s *Server) setTopic(user *User, ch *Channel, topic string) {
 	if ch == nil {
 		return
 	}
+	if reason := s.txDenied(user); user.Local() && reason != "" {
+		s.reply(user, ERR_CHANOPRIVSNEEDED, user.Nick, ch.Name, "Cannot set topic: "+reason)
+		return
+	}
 	s.Lock()
 
 	ch.Topic = topic
 	ch.TopicWho = user.Nick
 	ch.TopicTime = time.Now()
+	ch.LastSeen = ch.TopicTime
 
 	recipients := make([]*User, 0, len(ch.Users))
 	for _, u := range ch.Users {
 		recipients = append(recipients, u)
 	}
 	chName := ch.Name
-	userID := user.ID()
-	var tncWriter io.Writer
-	if user.Local() && s.tnc != nil {
-		tncWriter = s.tnc.Port(uint8(s.tncport))
-	}
 	s.Unlock()
 
 	for _, recipient := range recipients {
@@ -771,7 +1027,7 @@ func (s *Server) setTopic(user *User, ch *Channel, topic string) {
 	}
 
 	// also push out topic change
-	if tncWriter != nil {
-		fmt.Fprintf(tncWriter, ":%s %s %s :%s", userID, "TOPIC", chName, topic)
+	if user.Local() {
+		s.transmit(user, nil, "TOPIC", chName, topic)
 	}
 }
@@ -0,0 +1,185 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Messages may be signed, in the clear, so stations can tell who really
+// sent them. A signed radio line carries the time it was signed, in Unix
+// seconds, in the t tag, and in the s tag the Ed25519 signature of that
+// time, a space, and the line without its tags:
+//
+//	@s=<base64>;t=<seconds> :nick!CALL@Real_Name PRIVMSG #channel :text
+//
+// The time keeps a recorded line from being replayed later. A
+// fragmented message is signed as a whole and each fragment carries the
+// signature.
+const (
+	sigTag     = "s"
+	sigTimeTag = "t"
+
+	// sigMaxAge is how far a signed message's time may be from when it
+	// is heard. It allows for clock error and for messages held back for
+	// airtime or retransmitted for want of an ACK.
+	sigMaxAge = 15 * time.Minute
+
+	// sigStatusTag tells IRC clients that negotiated message-tags how a
+	// message heard over the radio checked out.
+	sigStatusTag = "hamirc/sig"
+
+	sigVerified   = "verified"
+	sigUnverified = "unverified"
+	sigForged     = "forged"
+	// sigReplayed is for a good signature that is too old, or already
+	// heard; such messages are dropped.
+	sigReplayed = "replayed"
+
+	// forgedMark is put in front of messages that fail their signature
+	// check, for clients that do not see tags.
+	forgedMark = "[forged] "
+)
+
+// sigEncoding encodes signatures and keys. It keeps tag values free of
+// characters that need escaping.
+var sigEncoding = base64.RawURLEncoding
+
+// Keyring maps base callsigns to the public keys their messages are
+// checked against.
+type Keyring map[string]ed25519.PublicKey
+
+// SigningKeys maps base callsigns to the private keys messages
+// transmitted for them are signed with.
+type SigningKeys map[string]ed25519.PrivateKey
+
+// LoadKeyring reads a keyring file. Each line holds a callsign and a
+// public key made by GenerateSigningKey, separated by whitespace.
+// Blank lines and lines starting with # are ignored.
+func LoadKeyring(path string) (Keyring, error) {
+	keys, err := readKeyFile(path, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	keyring := make(Keyring, len(keys))
+	for call, key := range keys {
+		keyring[call] = ed25519.PublicKey(key)
+	}
+	return keyring, nil
+}
+
+// LoadSigningKeys reads a signing keys file, laid out like a keyring
+// but with the private keys made by GenerateSigningKey.
+func LoadSigningKeys(path string) (SigningKeys, error) {
+	keys, err := readKeyFile(path, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	signing := make(SigningKeys, len(keys))
+	for call, seed := range keys {
+		signing[call] = ed25519.NewKeyFromSeed(seed)
+	}
+	return signing, nil
+}
+
+func readKeyFile(path string, size int) (map[string][]byte, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(fh)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a callsign and a key", path, lineNo)
+		}
+		key, err := sigEncoding.DecodeString(fields[1])
+		if err != nil || len(key) != size {
+			return nil, fmt.Errorf("%s:%d: bad key for %s", path, lineNo, fields[0])
+		}
+		keys[baseCallsign(fields[0])] = key
+	}
+	return keys, scanner.Err()
+}
+
+// GenerateSigningKey returns a new key pair, encoded for a keyring and
+// a signing keys file.
+func GenerateSigningKey() (public, private string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return sigEncoding.EncodeToString(pub), sigEncoding.EncodeToString(priv.Seed()), nil
+}
+
+// signed reports whether cmd is one of the commands that get signed.
+func signed(cmd string) bool {
+	return cmd == "PRIVMSG" || cmd == "NOTICE" || cmd == "TOPIC" || cmd == "ACK" || presence(cmd)
+}
+
+// signedData returns what is signed for a message signed at signedAt, a
+// t tag value.
+func signedData(signedAt, prefix, cmd, target, text string) []byte {
+	return []byte(signedAt + " " + radioLine(nil, prefix, cmd, target, text))
+}
+
+// signTags returns tags with a signature added if the message is one
+// that gets signed and user has a signing key. Only users logged in as
+// their callsign have their messages signed.
+func (s *Server) signTags(user *User, tags wireTags, cmd, target, text string) wireTags {
+	key := s.SigningKeys[baseCallsign(user.Callsign)]
+	if key == nil || !signed(cmd) || !user.authenticated() {
+		return tags
+	}
+	signedAt := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := ed25519.Sign(key, signedData(signedAt, user.ID(), cmd, target, text))
+	signedTags := wireTags{sigTag: sigEncoding.EncodeToString(sig), sigTimeTag: signedAt}
+	for k, v := range tags {
+		signedTags[k] = v
+	}
+	return signedTags
+}
+
+// checkSignature checks the signature on a message heard over the
+// radio from callsign at heard. Stations with a key in the keyring are
+// expected to sign everything, so a message from one without a good
+// signature is forged; messages from anyone else are unverified. A good
+// signature made more than sigMaxAge from heard, or heard before within
+// that time, is a replay. Retransmissions of messages that asked for an
+// ACK repeat their signature, and are left to ackReceived.
+func (s *Server) checkSignature(tags wireTags, args []string, callsign string, heard time.Time) string {
+	key, ok := s.Keyring[baseCallsign(callsign)]
+	text := strings.Join(args[3:], " ")
+	switch {
+	case !ok:
+		return sigUnverified
+	case tags[fragmentTag] != "" && strings.HasPrefix(text, incompleteMark):
+		// parts are missing, so there is nothing to check
+		return sigUnverified
+	}
+	sig, err := sigEncoding.DecodeString(tags[sigTag])
+	signedAt, terr := strconv.ParseInt(tags[sigTimeTag], 10, 64)
+	if err != nil || terr != nil || !ed25519.Verify(key, signedData(tags[sigTimeTag], args[0], args[1], args[2], text), sig) {
+		return sigForged
+	}
+	if heard.Sub(time.Unix(signedAt, 0)).Abs() > sigMaxAge {
+		return sigReplayed
+	}
+	if tags[ackTag] == "" && s.sigReplays.duplicate(tags[sigTag], heard, sigMaxAge) {
+		return sigReplayed
+	}
+	return sigVerified
+}
@@ -0,0 +1,101 @@
+package irc
+
+import (
+	"crypto/ed25519"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	s := NewServer()
+	public, private, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, _ := sigEncoding.DecodeString(private)
+	pub, _ := sigEncoding.DecodeString(public)
+	s.SigningKeys = SigningKeys{"K1ABC": ed25519.NewKeyFromSeed(seed)}
+	s.Keyring = Keyring{"K1ABC": ed25519.PublicKey(pub)}
+
+	user := NewUser("ham", nil)
+	user.Callsign, user.RealName = "K1ABC-7", "Ham"
+	if tags := s.signTags(user, nil, "PRIVMSG", "#net", "hello"); tags[sigTag] != "" {
+		t.Fatal("signed for a user who has not logged in")
+	}
+	user.account = "K1ABC"
+	tags := s.signTags(user, wireTags{ackTag: "abc"}, "PRIVMSG", "#net", "hello")
+	if tags[sigTag] == "" || tags[sigTimeTag] == "" || tags[ackTag] != "abc" {
+		t.Fatalf("signTags = %v", tags)
+	}
+
+	now := time.Now()
+	check := func(line, call string, heard time.Time) string {
+		tags, line := splitTags(line)
+		return s.checkSignature(tags, parse(line), call, heard)
+	}
+	lateTags := wireTags{sigTag: tags[sigTag], sigTimeTag: "1700000000", ackTag: "abc"}
+	tests := []struct {
+		line, call string
+		heard      time.Time
+		want       string
+	}{
+		{radioLine(tags, user.ID(), "PRIVMSG", "#net", "hello"), "K1ABC-7", now, sigVerified},
+		{radioLine(tags, user.ID(), "PRIVMSG", "#net", "hellO"), "K1ABC-7", now, sigForged},
+		{radioLine(tags, user.ID(), "PRIVMSG", "#other", "hello"), "K1ABC-7", now, sigForged},
+		{radioLine(nil, user.ID(), "PRIVMSG", "#net", "hello"), "K1ABC-7", now, sigForged},
+		{radioLine(lateTags, user.ID(), "PRIVMSG", "#net", "hello"), "K1ABC-7", now, sigForged},
+		{radioLine(tags, user.ID(), "PRIVMSG", "#net", "hello"), "K1ABC-7", now.Add(time.Hour), sigReplayed},
+		{radioLine(nil, "far!W1AW@x", "PRIVMSG", "#net", "hello"), "W1AW", now, sigUnverified},
+	}
+	for _, test := range tests {
+		if got := check(test.line, test.call, test.heard); got != test.want {
+			t.Errorf("checkSignature(%q) = %s, want %s", test.line, got, test.want)
+		}
+	}
+
+	conn, r := testClient(t, s, "CAP REQ :message-tags", "CAP END", "NICK local", "USER W1AW 0 * :Hiram", "JOIN #net")
+	expect(t, conn, r, RPL_ENDOFNAMES)
+	s.handleRadioLine("", radioLine(nil, user.ID(), "PRIVMSG", "#net", "send money"), time.Now())
+	expect(t, conn, r, "PRIVMSG #net :"+forgedMark+"send money")
+	tags = s.signTags(user, nil, "PRIVMSG", "#net", "hello")
+	line := radioLine(tags, user.ID(), "PRIVMSG", "#net", "hello")
+	s.handleRadioLine("", line, time.Now())
+	expect(t, conn, r, "hamirc/sig=verified")
+	// a recording played back after the dedup window has passed
+	s.handleRadioLine("", line, time.Now().Add(time.Minute))
+	if got := s.Stats()["replayed messages dropped"]; got != 1 {
+		t.Errorf("replays dropped: %d, want 1", got)
+	}
+}
+
+func TestAckChecks(t *testing.T) {
+	s := NewServer()
+	s.AckRetries = 1
+	sender := NewUser("ham", io.Discard)
+	far := NewUser("far", nil)
+	far.Callsign = "W1AW"
+	s.Users[nickKey(far.Nick)] = far
+	pending := func() int {
+		s.acks.mu.Lock()
+		defer s.acks.mu.Unlock()
+		return len(s.acks.pending)
+	}
+
+	s.acks.pending = map[string]*pendingAck{"abc123": {sender: sender, target: "far", callsign: "W1AW", timer: time.NewTimer(time.Hour)}}
+	s.handleRadioLine("N0CALL", ":far!N0CALL@x ACK ham :abc123", time.Now())
+	if pending() != 1 {
+		t.Fatal("ACK from the wrong callsign accepted")
+	}
+	ban, _ := newBan("call:W1AW", "")
+	s.Bans = []*Ban{ban}
+	s.handleRadioLine("W1AW", ":far!W1AW@x ACK ham :abc123", time.Now())
+	if pending() != 1 {
+		t.Fatal("ACK from a banned station accepted")
+	}
+	s.Bans = nil
+	s.handleRadioLine("W1AW", ":far!W1AW@x ACK ham :abc123", time.Now().Add(time.Minute))
+	if pending() != 0 {
+		t.Fatal("ACK not accepted")
+	}
+}
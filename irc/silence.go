@@ -0,0 +1,90 @@
+package irc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// silenceMax is how many masks each callsign may silence, as
+// advertised in RPL_ISUPPORT.
+const silenceMax = 32
+
+// silenced reports whether recipient, a local user, has silenced
+// messages from sender. The caller must hold the server lock.
+func (s *Server) silenced(recipient, sender *User, target, text string) bool {
+	if !recipient.Local() || recipient == sender {
+		return false
+	}
+	for _, b := range s.Silences[baseCallsign(recipient.Callsign)] {
+		if b.matches(sender.ID(), sender.Callsign, target, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// silence handles the SILENCE command. Masks take the same forms as
+// bans, and are kept for the user's callsign, so they apply to all of
+// its sessions and survive restarts.
+func silence(s *Server, user *User, args []string) (quit bool) {
+	call := baseCallsign(user.Callsign)
+	if len(args) == 1 {
+		s.Lock()
+		var masks []string
+		for _, b := range s.Silences[call] {
+			masks = append(masks, b.Mask)
+		}
+		s.Unlock()
+		for _, mask := range masks {
+			s.reply(user, RPL_SILELIST, user.Nick, mask)
+		}
+		s.reply(user, RPL_ENDOFSILELIST, user.Nick, "End of Silence List")
+		return
+	}
+
+	for _, arg := range strings.Split(args[1], ",") {
+		remove := strings.HasPrefix(arg, "-")
+		b, err := newBan(strings.TrimLeft(arg, "+-"), "")
+		if err != nil {
+			s.reply(user, "NOTICE", user.Nick, fmt.Sprintf("Bad mask %s: %s", arg, err))
+			continue
+		}
+		mask := b.Mask
+
+		s.Lock()
+		masks := s.Silences[call]
+		i := slices.IndexFunc(masks, func(existing *Ban) bool { return existing.Mask == mask })
+		switch {
+		case remove && i < 0, !remove && i >= 0:
+			s.Unlock()
+			continue
+		case remove:
+			masks = slices.Delete(masks, i, i+1)
+		case len(masks) >= silenceMax:
+			s.Unlock()
+			s.reply(user, ERR_SILELISTFULL, user.Nick, mask, "Your silence list is full")
+			continue
+		default:
+			b.SetBy, b.SetAt = user.ID(), time.Now()
+			masks = append(masks, b)
+		}
+		if s.Silences == nil {
+			s.Silences = make(map[string][]*Ban)
+		}
+		if len(masks) == 0 {
+			delete(s.Silences, call)
+		} else {
+			s.Silences[call] = masks
+		}
+		s.Unlock()
+
+		change := "+"
+		if remove {
+			change = "-"
+		}
+		fmt.Fprintf(user, ":%s SILENCE %s%s\r\n", user.ID(), change, mask)
+	}
+	return
+}
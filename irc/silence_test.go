@@ -0,0 +1,61 @@
+package irc
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSilence(t *testing.T) {
+	s := NewServer()
+	conn, r := testClient(t, s, "NICK ham", "USER K1ABC-7 0 * :Ham", "JOIN #net",
+		"SILENCE +far,call:W2XYZ", "SILENCE")
+	expect(t, conn, r, "SILENCE=32")
+	expect(t, conn, r, "SILENCE +far!*@*")
+	expect(t, conn, r, "SILENCE +call:W2XYZ")
+	expect(t, conn, r, RPL_SILELIST+" ham :far!*@*")
+	expect(t, conn, r, RPL_ENDOFSILELIST)
+
+	s.handleRadioLine("", ":far!W1AW@x PRIVMSG #net :chatter", time.Now())
+	s.handleRadioLine("", ":other!W2XYZ-1@x PRIVMSG ham :psst", time.Now())
+	s.handleRadioLine("", ":near!N0CALL@x PRIVMSG #net :hello", time.Now())
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("waiting for hello: %s", err)
+		}
+		if strings.Contains(line, "chatter") || strings.Contains(line, "psst") {
+			t.Fatalf("silenced message delivered: %q", line)
+		}
+		if strings.Contains(line, ":hello") {
+			break
+		}
+	}
+
+	s.Lock()
+	masks := s.Silences["K1ABC"]
+	s.Unlock()
+	if len(masks) != 2 {
+		t.Errorf("%d silences stored for K1ABC, want 2", len(masks))
+	}
+
+	// silences survive a restart ready to match
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := s.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewServer()
+	if err := restored.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	ham := &User{Nick: "ham", Callsign: "K1ABC", local: true}
+	far := &User{Nick: "far", Callsign: "W1AW", RealName: "x"}
+	restored.Lock()
+	silenced := restored.silenced(ham, far, "#net", "chatter")
+	restored.Unlock()
+	if !silenced {
+		t.Error("silence not applied after Load")
+	}
+}
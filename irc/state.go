@@ -1,7 +1,6 @@
 package irc
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"hash/fnv"
@@ -106,8 +105,13 @@ func (s *Server) Load(path string) error {
 
 	// cycle through Users, set their non-exported fields
 	normalizedUsers := make(UserMap, len(s.Users))
+	// state saved before expiry was added has no times; count from now
+	now := time.Now()
 	for _, user := range s.Users {
-		user.buf = bufio.NewWriter(io.Discard)
+		user.out = io.Discard
+		if user.LastSeen.IsZero() {
+			user.LastSeen = now
+		}
 		normalizedUsers[nickKey(user.Nick)] = user
 	}
 	s.Users = normalizedUsers
@@ -116,6 +120,9 @@ func (s *Server) Load(path string) error {
 	normalizedChannels := make(map[string]*Channel, len(s.Channels))
 	for _, ch := range s.Channels {
 		ch.Mutex = &sync.Mutex{}
+		if ch.LastSeen.IsZero() {
+			ch.LastSeen = now
+		}
 		if ch.Users == nil {
 			ch.Users = make(map[string]*User)
 		}
@@ -134,6 +141,32 @@ func (s *Server) Load(path string) error {
 		normalizedChannels[channelKey(ch.Name)] = ch
 	}
 	s.Channels = normalizedChannels
+
+	bans := s.Bans[:0]
+	for _, b := range s.Bans {
+		if err := b.compile(); err != nil {
+			log.Printf("Dropping bad ban %s: %s", b.Mask, err)
+			continue
+		}
+		bans = append(bans, b)
+	}
+	s.Bans = bans
+
+	for call, silences := range s.Silences {
+		masks := silences[:0]
+		for _, b := range silences {
+			if err := b.compile(); err != nil {
+				log.Printf("Dropping bad silence %s for %s: %s", b.Mask, call, err)
+				continue
+			}
+			masks = append(masks, b)
+		}
+		if len(masks) == 0 {
+			delete(s.Silences, call)
+		} else {
+			s.Silences[call] = masks
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,52 @@
+package irc
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// stats counts events on the radio side of the server, such as frames
+// dropped and why, for the STATS command.
+type stats struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func (st *stats) add(name string, n uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.counts == nil {
+		st.counts = make(map[string]uint64)
+	}
+	st.counts[name] += n
+}
+
+func (st *stats) snapshot() map[string]uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return maps.Clone(st.counts)
+}
+
+// Stats returns a copy of the server's counters.
+func (s *Server) Stats() map[string]uint64 {
+	counts := s.stats.snapshot()
+	if counts == nil {
+		counts = make(map[string]uint64)
+	}
+	return counts
+}
+
+func (s *Server) listStats(user *User, query string) {
+	if query == "b" {
+		s.listBanStats(user)
+		s.reply(user, RPL_ENDOFSTATS, user.Nick, query, "End of /STATS report")
+		return
+	}
+	counts := s.Stats()
+	for _, name := range slices.Sorted(maps.Keys(counts)) {
+		s.reply(user, RPL_STATSDEBUG, user.Nick, fmt.Sprintf("%s: %d", name, counts[name]))
+	}
+	s.reply(user, RPL_ENDOFSTATS, user.Nick, query, "End of /STATS report")
+}
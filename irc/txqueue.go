@@ -0,0 +1,221 @@
+package irc
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// txPriority orders frames waiting to be transmitted; lower goes first.
+type txPriority int
+
+const (
+	txUrgent txPriority = iota // station ID, ACKs, and private messages
+	txChat                     // channel messages
+	txBulk                     // topics and presence
+	txClasses
+)
+
+const (
+	// DefaultCoalesceWindow is how long a short line waits for more
+	// lines from the same user to the same target to share its frame.
+	DefaultCoalesceWindow = 500 * time.Millisecond
+
+	// TXQueueDepth is how many frames may wait to be transmitted before
+	// further ones are refused.
+	TXQueueDepth = 256
+)
+
+// A frame may carry several radio lines, separated by line feeds, to
+// save keying up the transmitter for each.
+const lineSeparator = "\n"
+
+// txFrame is a frame waiting to be transmitted.
+type txFrame struct {
+	user *User  // who the frame is sent for; nil for station ID
+	src  string // source callsign
+	// key is shared by frames from one user to one target, which are
+	// kept in order; "" for frames that need not be. Short lines may
+	// merge into the last frame with the same key.
+	key   string
+	short bool
+	lines []string
+	data  []byte    // a ready-made frame, used instead of lines
+	ready time.Time // not sent before then, so more lines can join it
+}
+
+// txQueue holds frames waiting to be transmitted, in priority order.
+// Within a class frames go out in the order they were queued, except
+// that frames held for coalescing let others go first.
+type txQueue struct {
+	mu     sync.Mutex
+	frames [txClasses][]*txFrame
+	n      int
+	wake   chan struct{}
+}
+
+func newTXQueue() *txQueue {
+	return &txQueue{wake: make(chan struct{}, 1)}
+}
+
+// push queues f at now. If f is short and the last frame waiting in its
+// class with the same key is too, is still held for coalescing at now,
+// and has room for f's lines within mtu, the lines join that frame and
+// push reports that they merged.
+func (q *txQueue) push(f *txFrame, prio txPriority, mtu int, now time.Time) (merged bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	frames := q.frames[prio]
+	for i := len(frames) - 1; i >= 0 && f.key != ""; i-- {
+		last := frames[i]
+		if last.key != f.key {
+			continue
+		}
+		size := len(strings.Join(last.lines, lineSeparator)) + len(lineSeparator) + len(strings.Join(f.lines, lineSeparator))
+		if f.short && last.short && last.ready.After(now) && mtu > 0 && size <= mtu {
+			last.lines = append(last.lines, f.lines...)
+			return true, nil
+		}
+		break
+	}
+	if q.n >= TXQueueDepth {
+		return false, ErrLinkQueueFull
+	}
+	q.frames[prio] = append(frames, f)
+	q.n++
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return false, nil
+}
+
+// pop removes and returns the first frame ready at now from the most
+// urgent class that has one. Frames held for coalescing hold back later
+// frames with the same key, so they stay in order. If no frame
+// is ready, pop returns how long until one will be, or 0 if the queue
+// is empty.
+func (q *txQueue) pop(now time.Time) (*txFrame, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var wait time.Duration
+	for prio, frames := range q.frames {
+		var held []string
+		for i, f := range frames {
+			if f.ready.After(now) {
+				held = append(held, f.key)
+				if d := f.ready.Sub(now); wait == 0 || d < wait {
+					wait = d
+				}
+				continue
+			}
+			if f.key != "" && slices.Contains(held, f.key) {
+				continue
+			}
+			q.frames[prio] = slices.Delete(frames, i, i+1)
+			q.n--
+			return f, 0
+		}
+	}
+	return nil, wait
+}
+
+// txFrameFor returns a frame carrying a radio line sent for user, and
+// its class. Short PRIVMSGs and NOTICEs are held for CoalesceWindow.
+func (s *Server) txFrameFor(user *User, line string, now time.Time) (*txFrame, txPriority) {
+	f := &txFrame{user: user, src: user.Callsign, lines: []string{line}, ready: now}
+	_, rest := splitTags(line)
+	args := parse(rest)
+	if len(args) < 3 {
+		return f, txBulk
+	}
+	cmd, target := args[1], args[2]
+	if cmd == "ACK" {
+		return f, txUrgent
+	}
+	f.key = nickKey(user.Nick) + " " + strings.ToLower(target)
+	var prio txPriority
+	switch {
+	case cmd != "PRIVMSG" && cmd != "NOTICE":
+		return f, txBulk
+	case strings.HasPrefix(target, "#"):
+		prio = txChat
+	default:
+		prio = txUrgent
+	}
+	if s.MTU <= 0 || len(line) <= s.MTU/2 {
+		f.short = true
+		f.ready = now.Add(s.CoalesceWindow)
+	}
+	return f, prio
+}
+
+// queueLines queues radio lines to be transmitted for user. Airtime was
+// reserved for each line in a frame of its own, so the keying up and
+// headers saved by lines sharing a frame are refunded.
+func (s *Server) queueLines(user *User, lines []string) bool {
+	now := time.Now()
+	for _, line := range lines {
+		f, prio := s.txFrameFor(user, line, now)
+		merged, err := s.txq.push(f, prio, s.MTU, now)
+		if err != nil {
+			s.notTransmitted(user, err)
+			return false
+		}
+		if merged {
+			s.refundAirtime(user.Callsign, s.mergedAirtime(user.Callsign), now)
+		}
+	}
+	if !s.link.Up() {
+		s.reply(user, "NOTICE", user.Nick, "Radio link is down; your message is queued.")
+	}
+	return true
+}
+
+// transmitter hands queued frames to the TNC as they become ready.
+// With Bitrate set it waits out each frame's airtime before the next,
+// so frames wait here, where they can be put in order, and not in the
+// TNC.
+func (s *Server) transmitter() {
+	for {
+		f, wait := s.txq.pop(time.Now())
+		if f == nil {
+			if wait == 0 {
+				<-s.txq.wake
+				continue
+			}
+			select {
+			case <-s.txq.wake:
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		data := f.data
+		if data == nil {
+			var err error
+			data, err = s.encodeFrame(f.src, strings.Join(f.lines, lineSeparator))
+			if err != nil {
+				s.notTransmitted(f.user, err)
+				continue
+			}
+			s.debugf("<TNC> transmitting %q", f.lines)
+		}
+		if err := s.link.Send(data); err != nil {
+			if f.user == nil {
+				log.Printf("Could not transmit for %s: %s", f.src, err)
+			} else {
+				s.notTransmitted(f.user, err)
+			}
+			continue
+		}
+		if f.user != nil && s.IDCall != "" {
+			s.ider.transmitted(f.src, time.Now())
+		}
+		if s.Bitrate > 0 {
+			time.Sleep(s.frameAirtime(data))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTXQueue(t *testing.T) {
+	s := NewServer()
+	s.MTU = 100
+	user := NewUser("ham", nil)
+	user.Callsign = "K1ABC"
+	s.CoalesceWindow = time.Second
+	now := time.Now()
+
+	pushAt := func(line string, now time.Time) {
+		t.Helper()
+		f, prio := s.txFrameFor(user, line, now)
+		if _, err := s.txq.push(f, prio, s.MTU, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	push := func(line string) { pushAt(line, now) }
+	push(radioLine(nil, user.ID(), "TOPIC", "#net", "new topic"))
+	push(radioLine(nil, user.ID(), "PRIVMSG", "#net", "one"))
+	push(radioLine(nil, user.ID(), "PRIVMSG", "#net", "two"))
+	push(radioLine(nil, user.ID(), "PRIVMSG", "#net", strings.Repeat("long ", 12)))
+	push(radioLine(nil, user.ID(), "PRIVMSG", "far", "psst"))
+	push(radioLine(nil, "far!W1AW@x", "ACK", "ham", "abc123"))
+
+	var got []string
+	for now := now; ; now = now.Add(time.Second) {
+		f, wait := s.txq.pop(now)
+		if f == nil && wait == 0 {
+			break
+		}
+		for f != nil {
+			got = append(got, strings.Join(f.lines, " / "))
+			f, _ = s.txq.pop(now)
+		}
+	}
+	want := []string{
+		// ready at once: the ACK, then the topic; the short lines wait
+		"ACK ham :abc123",
+		"TOPIC #net :new topic",
+		// then the held private message before channel chat, where the
+		// two short lines share a frame and the long one keeps its place
+		"PRIVMSG far :psst",
+		"PRIVMSG #net :one / :ham!K1ABC@ PRIVMSG #net :two",
+		"PRIVMSG #net :long",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("frames sent:\n%s", strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("frame %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// a line sent once the window has passed gets its own frame, even
+	// if the earlier one has not gone out yet
+	pushAt(radioLine(nil, user.ID(), "PRIVMSG", "#net", "early"), now)
+	pushAt(radioLine(nil, user.ID(), "PRIVMSG", "#net", "late"), now.Add(2*time.Second))
+	for _, want := range []string{"PRIVMSG #net :early", "PRIVMSG #net :late"} {
+		f, _ := s.txq.pop(now.Add(time.Minute))
+		if f == nil || len(f.lines) != 1 || !strings.Contains(f.lines[0], want) {
+			t.Fatalf("got frame %v, want only %q", f, want)
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package irc
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io"
-	"net"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,42 +18,147 @@ type User struct {
 	Callsign string
 	RealName string
 	LastSeen time.Time
-	conn     net.Conn
 	local    bool
+	// rxonly is set for local users who may not transmit.
+	rxonly bool
+	// registered is set once NICK and USER have been accepted.
+	registered bool
+	// password is the PASS given before registration.
+	password string
+	// account is the base callsign the user authenticated as, if any.
+	account string
+	// cert is the base callsign named by the verified TLS client
+	// certificate the user connected with, if any.
+	cert string
+	// away is the AWAY message of a local user; empty if they are here.
+	// It is guarded by the server lock.
+	away string
 
-	buf *bufio.Writer
+	mu      sync.Mutex
+	partial []byte
+	out     io.Writer
+	clients []*client
+	// session is set for bouncer sessions, which outlive their client
+	// connections. Lines written while no client is attached are kept
+	// in backlog, up to backlogMax lines.
+	session    bool
+	backlog    []string
+	backlogMax int
 }
 
 func NewUser(nick string, wr io.Writer) *User {
 	return &User{
 		Nick: nick,
-		buf:  bufio.NewWriter(wr),
+		out:  wr,
 	}
 }
 
 // Write writes to the user, in discrete lines, buffering if we did
 // not get a line-feed.
 func (u *User) Write(buf []byte) (n int, err error) {
-	var n2 int
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	n = len(buf)
 	for len(buf) > 0 {
-		i := bytes.Index(buf, []byte{'\n'})
+		i := bytes.IndexByte(buf, '\n')
 		if i == -1 {
-			n2, err = u.buf.Write(buf)
-			n += n2
-			return n, err
-		}
-		n2, err = u.buf.Write(buf[:i+1])
-		n += n2
-		if err != nil {
+			u.partial = append(u.partial, buf...)
 			return n, err
 		}
-		if err := u.buf.Flush(); err != nil {
-			return n, err
+		line := append(u.partial, buf[:i+1]...)
+		u.partial = nil
+		if werr := u.writeLine(line); werr != nil {
+			err = werr
 		}
 		buf = buf[i+1:]
 	}
 
-	return n, nil
+	return n, err
+}
+
+// writeLine sends a complete line to every attached client, or, for a
+// detached session, adds it to the backlog.
+func (u *User) writeLine(line []byte) error {
+	switch {
+	case len(u.clients) > 0:
+		var err error
+		for _, c := range u.clients {
+			if werr := c.writeLine(line); werr != nil {
+				err = werr
+			}
+		}
+		return err
+	case u.session:
+		u.backlog = append(u.backlog, string(line))
+		if over := len(u.backlog) - u.backlogMax; over > 0 {
+			u.backlog = slices.Delete(u.backlog, 0, over)
+		}
+		return nil
+	case u.out != nil:
+		_, err := u.out.Write(line)
+		return err
+	}
+	return nil
+}
+
+// attach adds a client connection to the user, first replaying any
+// backlog to it. It returns how many lines were replayed.
+func (u *User) attach(c *client) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	replayed := len(u.backlog)
+	for _, line := range u.backlog {
+		c.writeLine([]byte(line))
+	}
+	u.backlog = nil
+	u.clients = append(u.clients, c)
+	c.user = u
+	return replayed
+}
+
+// detach removes a client connection from the user and returns how many
+// remain attached.
+func (u *User) detach(c *client) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.clients = slices.DeleteFunc(u.clients, func(other *client) bool { return other == c })
+	return len(u.clients)
+}
+
+// echo writes a line the user sent back to those of their clients that
+// negotiated echo-message.
+func (u *User) echo(line []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, c := range u.clients {
+		if c.hasCap("echo-message") {
+			c.writeLine(line)
+		}
+	}
+}
+
+// negotiating reports whether a client is still negotiating
+// capabilities, which holds off registration.
+func (u *User) negotiating() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, c := range u.clients {
+		c.mu.Lock()
+		negotiating := c.negotiating
+		c.mu.Unlock()
+		if negotiating {
+			return true
+		}
+	}
+	return false
+}
+
+// attached reports whether any client connection is attached.
+func (u *User) attached() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.clients) > 0
 }
 
 // ID generates a user id in the form of <nick>!<user>@<Real Name>. ID converts spaces to underscores
@@ -95,9 +200,11 @@ func (u *User) Local() bool {
 
 // Status returns H or G if a user is "Here" or "Gone". This is based on
 // LastSeen time. Any messaages within the last hour marks a user as here
-// and gone otherwise. Only PRIVMSG and NOTICE update LastSeen time.
+// and gone otherwise. Only PRIVMSG and NOTICE update LastSeen time, apart
+// from a remote user being heard for the first time. Users marked AWAY
+// are always gone.
 func (u *User) Status() string {
-	if time.Since(u.LastSeen) < time.Hour {
+	if u.away == "" && time.Since(u.LastSeen) < time.Hour {
 		return "H"
 	}
 	return "G"
@@ -0,0 +1,99 @@
+package irc
+
+import (
+	"slices"
+	"strings"
+)
+
+// Lines sent over the radio may start with IRCv3 style message tags:
+//
+//	@f=1a2b/1/3 :nick!CALL@Real_Name PRIVMSG #channel :text
+//
+// The tags are hamirc's own; their names are kept short to save airtime.
+type wireTags map[string]string
+
+// splitTags separates the leading tags, if any, from a radio line.
+func splitTags(line string) (wireTags, string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+	raw, rest, _ := strings.Cut(line[1:], " ")
+	tags := make(wireTags)
+	for _, tag := range strings.Split(raw, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		tags[key] = unescapeTagValue(value)
+	}
+	return tags, strings.TrimLeft(rest, " ")
+}
+
+// String formats the tags for the front of a line, including the
+// leading '@' and trailing space. Tags are sorted so the same tags
+// always produce the same bytes. Empty tags format as "".
+func (t wireTags) String() string {
+	if len(t) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	sb.WriteByte('@')
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		sb.WriteString(key)
+		if value := t[key]; value != "" {
+			sb.WriteByte('=')
+			sb.WriteString(escapeTagValue(value))
+		}
+	}
+	sb.WriteByte(' ')
+	return sb.String()
+}
+
+var tagEscaper = strings.NewReplacer(`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`)
+
+func escapeTagValue(value string) string {
+	return tagEscaper.Replace(value)
+}
+
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			if value[i] != '\\' {
+				sb.WriteByte(value[i])
+			}
+			continue
+		}
+		i++
+		switch value[i] {
+		case ':':
+			sb.WriteByte(';')
+		case 's':
+			sb.WriteByte(' ')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'n':
+			sb.WriteByte('\n')
+		default:
+			sb.WriteByte(value[i])
+		}
+	}
+	return sb.String()
+}
+
+// radioLine formats an IRC line as it is sent over the radio.
+func radioLine(tags wireTags, prefix, cmd, target, text string) string {
+	return tags.String() + ":" + prefix + " " + cmd + " " + target + " :" + text
+}
@@ -3,8 +3,11 @@ package kiss
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"io"
+	"sync"
+	"time"
 )
 
 const (
@@ -20,6 +23,21 @@ const (
 	TFESC = 0xDD // Transposed FESC
 )
 
+// Command is a KISS command, sent in the low nibble of a frame's type
+// byte. The high nibble carries the port number.
+type Command byte
+
+const (
+	CmdData        Command = 0x00 // Data frame
+	CmdTXDelay     Command = 0x01 // Keyup delay, in 10 ms units
+	CmdPersistence Command = 0x02 // Persistence parameter p, 0-255
+	CmdSlotTime    Command = 0x03 // Slot interval, in 10 ms units
+	CmdTXTail      Command = 0x04 // Time to hold after FCS, in 10 ms units
+	CmdFullDuplex  Command = 0x05 // 0 for half duplex, anything else for full
+	CmdSetHardware Command = 0x06 // TNC specific
+	CmdReturn      Command = 0xFF // Exit KISS mode; applies to all ports
+)
+
 var (
 	ErrInvalidPort = errors.New("invalid port: must be 0-7")
 )
@@ -27,23 +45,32 @@ var (
 // TODO: Add a slog logger that defaults to io.Discard;
 // Package function can change the destination.
 
+// TNC is a KISS TNC reached over rw. Frames read from rw are routed to
+// the Port they were received on.
 type TNC struct {
-	ports  [8]port
-	closed bool
+	ports [8]Port
+	wmu   sync.Mutex
+	done  chan struct{}
 }
 
-type port struct {
+// Port is one of the eight radio ports of a KISS TNC.
+type Port struct {
 	id    uint8
 	rw    io.ReadWriter
+	wmu   *sync.Mutex
 	queue chan []byte
+
+	mu      sync.Mutex
+	pending []byte
 }
 
 func NewTNC(rw io.ReadWriter) *TNC {
-	t := &TNC{}
+	t := &TNC{done: make(chan struct{})}
 	for i := range t.ports {
-		t.ports[i] = port{
+		t.ports[i] = Port{
 			id:    uint8(i),
 			rw:    rw,
+			wmu:   &t.wmu,
 			queue: make(chan []byte, QueueDepth),
 		}
 	}
@@ -62,61 +89,233 @@ func (t *TNC) router(rd io.Reader) {
 		if len(frame) == 0 {
 			continue
 		}
-		port := frame[0] >> 4 // will definitely be < 8
+		// only data frames carry traffic; TNCs don't send us commands
+		if Command(frame[0]&0x0F) != CmdData {
+			continue
+		}
+		port := frame[0] >> 4
+		if port > 7 {
+			continue
+		}
 		t.enqueue(port, frame[1:])
 	}
 
 	// There was an error with Scanner; most likely closed but regardless
 	// we cannot recover. Close all the queues so readers report EOF
+	close(t.done)
 	for i := range t.ports {
 		close(t.ports[i].queue)
 	}
-
-	t.closed = true
 }
 
 func (t *TNC) enqueue(port uint8, data []byte) {
-	// first check how much space we have left:
-	if t.ports[port].free() == 0 {
-		// discard one
-		<-t.ports[port].queue
+	for {
+		select {
+		case t.ports[port].queue <- data:
+			return
+		default:
+			// queue is full; discard the oldest frame
+			select {
+			case <-t.ports[port].queue:
+			default:
+			}
+		}
 	}
-
-	t.ports[port].queue <- data
 }
 
+// IsClosed reports whether the connection to the TNC has been lost.
 func (t *TNC) IsClosed() bool {
-	return t.closed
+	select {
+	case <-t.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel that is closed when the connection to the TNC
+// is lost.
+func (t *TNC) Done() <-chan struct{} {
+	return t.done
 }
 
-func (t *TNC) Port(n uint8) *port {
+// Port returns TNC port n. Values of n above 7 select port 7.
+func (t *TNC) Port(n uint8) *Port {
 	n = min(n, 7)
 	return &t.ports[n]
 }
 
-func (p *port) Read(data []byte) (n int, err error) {
-	frame, ok := <-p.queue // Block until a frame is available.
-	if !ok {
-		return 0, io.EOF // Channel closed.
+// ReadFrame blocks until a frame is received on the port or ctx is
+// done. It returns io.EOF once the connection to the TNC is lost.
+func (p *Port) ReadFrame(ctx context.Context) ([]byte, error) {
+	p.mu.Lock()
+	frame := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+	if frame != nil {
+		return frame, nil
+	}
+
+	select {
+	case frame, ok := <-p.queue:
+		if !ok {
+			return nil, io.EOF
+		}
+		return frame, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	copy(data, frame)
-	return len(frame), nil
 }
 
-func (p *port) Write(data []byte) (n int, err error) {
-	frame := FrameEncode(p.id<<4, data)
-	written, err := p.rw.Write(frame)
+// WriteFrame sends data to the port as a single KISS data frame.
+func (p *Port) WriteFrame(data []byte) error {
+	return p.writeRaw(FrameEncode(p.id<<4, data))
+}
+
+// Read reads one whole frame into data. If the frame does not fit, Read
+// returns io.ErrShortBuffer and keeps the frame for the next call to
+// Read or ReadFrame.
+func (p *Port) Read(data []byte) (n int, err error) {
+	frame, err := p.ReadFrame(context.Background())
 	if err != nil {
 		return 0, err
 	}
-	if written != len(frame) {
-		return 0, io.ErrShortWrite
+	if len(frame) > len(data) {
+		p.mu.Lock()
+		p.pending = frame
+		p.mu.Unlock()
+		return 0, io.ErrShortBuffer
+	}
+	return copy(data, frame), nil
+}
+
+// Write sends data as a single KISS data frame.
+func (p *Port) Write(data []byte) (n int, err error) {
+	if err := p.WriteFrame(data); err != nil {
+		return 0, err
 	}
 	return len(data), nil
 }
 
-func (p *port) free() int {
-	return cap(p.queue) - len(p.queue)
+func (p *Port) writeRaw(frame []byte) error {
+	if p.wmu != nil {
+		p.wmu.Lock()
+		defer p.wmu.Unlock()
+	}
+	written, err := p.rw.Write(frame)
+	if err != nil {
+		return err
+	}
+	if written != len(frame) {
+		return io.ErrShortWrite
+	}
+	return nil
+}
+
+// Command sends a KISS command with its parameter bytes to the port.
+// Use Write to send data frames.
+func (p *Port) Command(cmd Command, data ...byte) error {
+	typ := p.id<<4 | byte(cmd)&0x0F
+	if cmd == CmdReturn {
+		typ = byte(CmdReturn)
+	}
+	return p.writeRaw(CommandEncode(typ, data))
+}
+
+// SetTXDelay sets how long the TNC waits after keying the transmitter
+// before sending data. d is rounded down to 10 ms units, at most 2.55s.
+func (p *Port) SetTXDelay(d time.Duration) error {
+	return p.Command(CmdTXDelay, tenMillis(d))
+}
+
+// SetPersistence sets the p-persistence parameter; the TNC transmits
+// in a free slot with probability (persistence+1)/256.
+func (p *Port) SetPersistence(persistence uint8) error {
+	return p.Command(CmdPersistence, persistence)
+}
+
+// SetSlotTime sets the slot interval used with persistence.
+func (p *Port) SetSlotTime(d time.Duration) error {
+	return p.Command(CmdSlotTime, tenMillis(d))
+}
+
+// SetTXTail sets how long the transmitter is held on after the last
+// frame. Most modern TNCs ignore it.
+func (p *Port) SetTXTail(d time.Duration) error {
+	return p.Command(CmdTXTail, tenMillis(d))
+}
+
+// SetFullDuplex turns full duplex operation on or off.
+func (p *Port) SetFullDuplex(on bool) error {
+	var b byte
+	if on {
+		b = 1
+	}
+	return p.Command(CmdFullDuplex, b)
+}
+
+// SetHardware sends a TNC specific SetHardware command.
+func (p *Port) SetHardware(data []byte) error {
+	return p.Command(CmdSetHardware, data...)
+}
+
+// ExitKISS sends the Return command, taking the whole TNC out of KISS
+// mode.
+func (p *Port) ExitKISS() error {
+	return p.Command(CmdReturn)
+}
+
+// Apply sends every parameter set in params to the port.
+func (p *Port) Apply(params Params) error {
+	if params.TXDelay != nil {
+		if err := p.SetTXDelay(*params.TXDelay); err != nil {
+			return err
+		}
+	}
+	if params.Persistence != nil {
+		if err := p.SetPersistence(*params.Persistence); err != nil {
+			return err
+		}
+	}
+	if params.SlotTime != nil {
+		if err := p.SetSlotTime(*params.SlotTime); err != nil {
+			return err
+		}
+	}
+	if params.TXTail != nil {
+		if err := p.SetTXTail(*params.TXTail); err != nil {
+			return err
+		}
+	}
+	if params.FullDuplex != nil {
+		if err := p.SetFullDuplex(*params.FullDuplex); err != nil {
+			return err
+		}
+	}
+	for _, hw := range params.Hardware {
+		if err := p.SetHardware(hw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Params are TNC parameters to set on a port, e.g. at startup. Nil
+// fields are not sent, leaving the TNC at its current setting; zero is a
+// setting like any other.
+type Params struct {
+	TXDelay     *time.Duration
+	Persistence *uint8
+	SlotTime    *time.Duration
+	TXTail      *time.Duration
+	FullDuplex  *bool
+	// Hardware holds the parameters of SetHardware commands to send,
+	// one command per entry.
+	Hardware [][]byte
+}
+
+func tenMillis(d time.Duration) byte {
+	return byte(min(max(d/(10*time.Millisecond), 0), 255))
 }
 
 func FrameEncode(portCmd byte, data []byte) []byte {
@@ -124,6 +323,29 @@ func FrameEncode(portCmd byte, data []byte) []byte {
 	buf := bytes.NewBuffer(make([]byte, 0, len(data)+3))
 	buf.WriteByte(FEND)
 	buf.WriteByte(portCmd)
+	writeEscaped(buf, data)
+	// ensure we hit minimum frame size
+	if len(data) <= 14 {
+		buf.Write(bytes.Repeat([]byte{0}, 14-len(data)))
+	}
+	buf.WriteByte(FEND)
+
+	return buf.Bytes()
+}
+
+// CommandEncode is like FrameEncode but does not pad data out to the
+// minimum data frame size; TNCs read command parameters by length.
+func CommandEncode(portCmd byte, data []byte) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+3))
+	buf.WriteByte(FEND)
+	buf.WriteByte(portCmd)
+	writeEscaped(buf, data)
+	buf.WriteByte(FEND)
+
+	return buf.Bytes()
+}
+
+func writeEscaped(buf *bytes.Buffer, data []byte) {
 	for i := range len(data) {
 		switch data[i] {
 		case FEND:
@@ -134,13 +356,6 @@ func FrameEncode(portCmd byte, data []byte) []byte {
 			buf.WriteByte(data[i])
 		}
 	}
-	// ensure we hit minimum frame size
-	if len(data) <= 14 {
-		buf.Write(bytes.Repeat([]byte{0}, 14-len(data)))
-	}
-	buf.WriteByte(FEND)
-
-	return buf.Bytes()
 }
 
 // KissSplit is a bufio.SplitFunc for splitting KISS frames.
@@ -3,9 +3,11 @@ package kiss
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"testing"
+	"time"
 )
 
 func TestFrameEncodeDoesNotPrependZeros(t *testing.T) {
@@ -61,7 +63,7 @@ func TestSplitReadsEscapedFrame(t *testing.T) {
 }
 
 func TestPortWriteReportsShortWrite(t *testing.T) {
-	p := port{id: 1, rw: shortWriter{}}
+	p := Port{id: 1, rw: shortWriter{}}
 
 	n, err := p.Write([]byte("hello"))
 	if !errors.Is(err, io.ErrShortWrite) {
@@ -81,3 +83,93 @@ func (shortWriter) Read([]byte) (int, error) {
 func (shortWriter) Write(data []byte) (int, error) {
 	return len(data) - 1, nil
 }
+
+func TestPortCommands(t *testing.T) {
+	var buf bytes.Buffer
+	p := Port{id: 2, rw: &readWriter{Writer: &buf}}
+
+	txDelay, slotTime := 300*time.Millisecond, 100*time.Millisecond
+	persistence, fullDuplex := uint8(0), false
+	params := Params{
+		TXDelay:     &txDelay,
+		Persistence: &persistence,
+		SlotTime:    &slotTime,
+		FullDuplex:  &fullDuplex,
+		Hardware:    [][]byte{{0x01, FEND}},
+	}
+	if err := p.Apply(params); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if err := p.ExitKISS(); err != nil {
+		t.Fatalf("ExitKISS: %v", err)
+	}
+
+	want := []byte{
+		FEND, 0x21, 30, FEND,
+		FEND, 0x22, 0, FEND,
+		FEND, 0x23, 10, FEND,
+		FEND, 0x25, 0, FEND,
+		FEND, 0x26, 0x01, FESC, TFEND, FEND,
+		FEND, 0xFF, FEND,
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("commands = % X, want % X", buf.Bytes(), want)
+	}
+}
+
+func TestTXDelayClamps(t *testing.T) {
+	if got := tenMillis(10 * time.Second); got != 255 {
+		t.Fatalf("tenMillis(10s) = %d, want 255", got)
+	}
+	if got := tenMillis(-time.Second); got != 0 {
+		t.Fatalf("tenMillis(-1s) = %d, want 0", got)
+	}
+}
+
+type readWriter struct {
+	io.Reader
+	io.Writer
+}
+
+func TestPortReadShortBuffer(t *testing.T) {
+	payload := []byte("0123456789ABCDEFGHIJ")
+	tnc := NewTNC(&readWriter{Reader: bytes.NewReader(FrameEncode(0x10, payload)), Writer: io.Discard})
+	port := tnc.Port(1)
+
+	buf := make([]byte, 4)
+	n, err := port.Read(buf)
+	if !errors.Is(err, io.ErrShortBuffer) {
+		t.Fatalf("Read error = %v, want io.ErrShortBuffer", err)
+	}
+	if n != 0 {
+		t.Fatalf("n = %d, want 0", n)
+	}
+
+	// the frame is kept for the next read
+	frame, err := port.ReadFrame(context.Background())
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if !bytes.Equal(frame, payload) {
+		t.Fatalf("frame = %q, want %q", frame, payload)
+	}
+
+	if _, err := port.ReadFrame(context.Background()); !errors.Is(err, io.EOF) {
+		t.Fatalf("ReadFrame after close = %v, want io.EOF", err)
+	}
+	if !tnc.IsClosed() {
+		t.Fatal("TNC is not closed after EOF")
+	}
+}
+
+func TestReadFrameHonorsContext(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	tnc := NewTNC(&readWriter{Reader: r, Writer: io.Discard})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tnc.Port(0).ReadFrame(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ReadFrame error = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -8,25 +12,108 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/sparques/hamirc/ax25"
 	"github.com/sparques/hamirc/irc"
+	"github.com/sparques/hamirc/kiss"
 )
 
+// day is the unit of the expiry flags.
+const day = 24 * time.Hour
+
 var (
-	tncaddr   = flag.String("tnc", ":8001", "address of TNC")
-	name      = flag.String("name", "hamirc", "name of the server as sent to clients")
-	serve     = flag.String("serve", ":6667", "port and optionally address to listen on for IRC connections")
-	statefile = flag.String("state", "serverState.json", "path to file for loading/saving server state")
-	persist   = flag.Bool("persist", true, "if true, will load/save server state (users, channels, topics) to a file")
-	mustload  = flag.Bool("mustload", true, "if true, loading the state must succeed or program will exit; this is to prevent a server state file from being overwritten by an empty server state.")
-	autojoin  = flag.Bool("autojoin", true, "if true, will cause local users (those connected via TCP) to automatically join any channels that receive a message")
-	tncport   = flag.Int("tncport", 0, "the TNC port to use; valid options: 0-7;")
-	debug     = flag.Bool("debug", false, "if true, log raw IRC and TNC traffic")
+	tncaddr      = flag.String("tnc", ":8001", "address of TNC")
+	name         = flag.String("name", "hamirc", "name of the server as sent to clients")
+	serve        = flag.String("serve", ":6667", "port and optionally address to listen on for plaintext IRC connections; empty disables plaintext")
+	tlsserve     = flag.String("tlsserve", "", "port and optionally address to listen on for IRC connections over TLS, e.g. :6697; needs -tlscert and -tlskey")
+	tlscert      = flag.String("tlscert", "", "TLS certificate file (PEM) for -tlsserve")
+	tlskey       = flag.String("tlskey", "", "TLS private key file (PEM) for -tlsserve")
+	tlsclientca  = flag.String("tlsclientca", "", "CA bundle (PEM) for client certificates; a client presenting one is logged in as the callsign it was issued for")
+	tlsrequire   = flag.Bool("tlsrequirecert", false, "if true, TLS clients must present a certificate chained to -tlsclientca")
+	statefile    = flag.String("state", "serverState.json", "path to file for loading/saving server state")
+	persist      = flag.Bool("persist", true, "if true, will load/save server state (users, channels, topics) to a file")
+	mustload     = flag.Bool("mustload", true, "if true, loading the state must succeed or program will exit; this is to prevent a server state file from being overwritten by an empty server state.")
+	userexpiry   = flag.Int("userexpiry", int(irc.DefaultUserExpiry/day), "days a remote user may go unheard before being parted from their channels and forgotten; 0 never")
+	chanexpiry   = flag.Int("chanexpiry", int(irc.DefaultChannelExpiry/day), "days a channel may go without messages before it drops out of LIST, and is forgotten once empty; 0 never")
+	autojoin     = flag.Bool("autojoin", true, "if true, will cause local users (those connected via TCP) to automatically join any channels that receive a message")
+	tncport      = flag.Int("tncport", 0, "the TNC port to use; valid options: 0-7;")
+	debug        = flag.Bool("debug", false, "if true, log raw IRC and TNC traffic")
+	sendq        = flag.Int("sendq", irc.DefaultSendQ, "how many lines may be waiting for an IRC client before it is disconnected for falling behind")
+	pinginterval = flag.Duration("pinginterval", irc.DefaultPingInterval, "how often to PING IRC clients")
+	pingtimeout  = flag.Duration("pingtimeout", irc.DefaultPingTimeout, "how long an IRC client may leave a PING unanswered, while sending nothing else, before it is disconnected")
+	legacy       = flag.Bool("legacy", false, "if true, also accept bare-text frames sent by hamirc versions that predate AX.25 framing")
+	path         = flag.String("path", "", "comma separated digipeater path for transmitted frames, e.g. WIDE1-1,WIDE2-1")
+	mtu          = flag.Int("mtu", irc.DefaultMTU, "longest line, in bytes, sent in a single frame; longer messages are fragmented")
+	fragtimeout  = flag.Duration("fragtimeout", irc.DefaultFragmentTimeout, "how long to wait for missing fragments of a received message before delivering it marked [incomplete]")
+	presence     = flag.Bool("presence", true, "if true, transmit PART, QUIT and nick changes so other stations can keep their channel lists current")
+	dedupwindow  = flag.Duration("dedupwindow", irc.DefaultDedupWindow, "how long to remember received lines so that repeated copies (digipeaters, multiple paths) are dropped; 0 disables")
+	ack          = flag.Bool("ack", false, "if true, ask remote stations to acknowledge private messages and retransmit those that are not")
+	ackretries   = flag.Int("ackretries", irc.DefaultAckRetries, "how many times to retransmit an unacknowledged private message")
+	acktimeout   = flag.Duration("acktimeout", irc.DefaultAckTimeout, "how long to wait for an acknowledgement before the first retransmission; doubles with each try")
+
+	txdelay     = flag.Duration("txdelay", 0, "KISS TXDELAY to set on the TNC, e.g. 300ms; if not given, the TNC setting is left alone")
+	persistence = flag.Uint("persistence", 0, "KISS persistence (P) to set on the TNC, 0-255; if not given, the TNC setting is left alone")
+	slottime    = flag.Duration("slottime", 0, "KISS SLOTTIME to set on the TNC, e.g. 100ms; if not given, the TNC setting is left alone")
+	txtail      = flag.Duration("txtail", 0, "KISS TXTAIL to set on the TNC; if not given, the TNC setting is left alone")
+	fullduplex  = flag.Bool("fullduplex", false, "KISS full duplex setting for the TNC; if not given, the TNC setting is left alone")
+	sethardware = flag.String("sethardware", "", "comma separated hex strings, each sent to the TNC as a KISS SetHardware command")
+	exitkiss    = flag.Bool("exitkiss", false, "if true, send the KISS Return command on exit to take the TNC out of KISS mode")
+
+	idcall     = flag.String("idcall", "", "station callsign; if set, hamirc transmits station identification while it is transmitting")
+	idinterval = flag.Duration("idinterval", irc.DefaultIDInterval, "longest time to transmit without sending station identification")
+
+	baud          = flag.Int("baud", 0, "radio bit rate, e.g. 1200, used to estimate airtime; if set, transmissions are limited to -dutycycle and -userdutycycle")
+	dutycycle     = flag.Float64("dutycycle", irc.DefaultDutyCycle, "largest share of the time, 0 to 1, the station may spend transmitting; needs -baud")
+	userdutycycle = flag.Float64("userdutycycle", irc.DefaultUserDutyCycle, "largest share of the time, 0 to 1, any one callsign may spend transmitting; needs -baud")
+	airburst      = flag.Duration("airburst", irc.DefaultAirtimeBurst, "how much airtime may be used at once after a quiet spell; needs -baud")
+	maxtxwait     = flag.Duration("maxtxwait", irc.DefaultAirtimeMaxWait, "longest a message over the airtime budget is held back before it is refused instead; needs -baud")
+	coalesce      = flag.Duration("coalesce", irc.DefaultCoalesceWindow, "how long a short message waits for more from the same user to the same target to share its frame; 0 to send at once")
+
+	callpolicy       = flag.String("callpolicy", "allow", "what to do when a local user's USER ident is not a valid callsign: allow, rxonly, or refuse")
+	remotecallpolicy = flag.String("remotecallpolicy", "flag", "what to do with radio traffic from invalid callsigns: allow, flag, or refuse (drop)")
+	rxonly           = flag.Bool("rxonly", false, "if true, never transmit; local users can only monitor")
+	txallow          = flag.String("txallow", "", "comma separated callsigns allowed to transmit; if empty, any local user with a valid callsign may")
+
+	passwd     = flag.String("passwd", "", "credentials file, with a callsign and a password hash from -mkpasswd on each line; listed callsigns only transmit once logged in with PASS or SASL")
+	mkpasswd   = flag.Bool("mkpasswd", false, "read a password from standard input, print its hash for the -passwd file, and exit")
+	authpolicy = flag.String("authpolicy", "none", "what to do with local users who have not logged in: none (allow), rxonly, or refuse")
+	opers      = flag.String("opers", "", "comma separated callsigns of operators, who may manage bans once logged in")
+
+	bouncer = flag.Bool("bouncer", false, "if true, local users stay on the server when their client disconnects and get what they missed when they reconnect; requires -passwd or -tlsclientca")
+	backlog = flag.Int("backlog", irc.DefaultBacklogLines, "how many lines to keep for a detached bouncer session")
+
+	history      = flag.String("history", "", "directory to store message history in, for CHATHISTORY and -replay; empty disables history")
+	historylines = flag.Int("historylines", irc.DefaultHistoryLines, "how many messages to keep for each channel and private conversation")
+	replay       = flag.Int("replay", 0, "how many of a channel's last messages to send local users when they join it; needs -history")
+
+	signkeys = flag.String("signkeys", "", "file of callsigns and private keys from -mkkey; messages transmitted for logged in users with a key here are signed")
+	keyring  = flag.String("keyring", "", "file of callsigns and public keys from -mkkey; messages heard from these callsigns must carry a good signature")
+	mkkey    = flag.String("mkkey", "", "print a new signing key for the given callsign, as lines for -signkeys and -keyring, and exit")
 )
 
 func main() {
 	flag.Parse()
+	if *mkpasswd {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			log.Println("Couldn't read password:", err)
+			os.Exit(1)
+		}
+		fmt.Println(irc.HashPassword(strings.TrimRight(password, "\r\n")))
+		return
+	}
+	if *mkkey != "" {
+		public, private, err := irc.GenerateSigningKey()
+		if err != nil {
+			log.Println("Couldn't generate key:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("# -signkeys\n%s %s\n# -keyring\n%s %s\n", *mkkey, private, *mkkey, public)
+		return
+	}
 	server := irc.NewServer()
 	if *persist {
 		err := server.Load(*statefile)
@@ -39,10 +126,108 @@ func main() {
 		}
 		go server.PersistState(*statefile)
 	}
+	server.UserExpiry = time.Duration(*userexpiry) * day
+	server.ChannelExpiry = time.Duration(*chanexpiry) * day
 	// Automatically have local users join any newly seen channels
 	server.AutoJoin = *autojoin
 	server.Debug = *debug
+	server.SendQ = *sendq
+	server.PingInterval = *pinginterval
+	server.PingTimeout = *pingtimeout
 	server.Name = *name
+	server.Legacy = *legacy
+	digis, err := ax25.ParsePath(*path)
+	if err != nil {
+		log.Println("Invalid -path:", err)
+		os.Exit(1)
+	}
+	server.Path = digis
+	server.MTU = *mtu
+	server.FragmentTimeout = *fragtimeout
+	server.DedupWindow = *dedupwindow
+	server.Presence = *presence
+	if *ack {
+		server.AckRetries = *ackretries
+		server.AckTimeout = *acktimeout
+	}
+	if *idcall != "" {
+		if _, err := ax25.ParseAddress(*idcall); err != nil {
+			log.Println("Invalid -idcall:", err)
+			os.Exit(1)
+		}
+	}
+	server.IDCall = *idcall
+	server.IDInterval = *idinterval
+	if *dutycycle <= 0 || *dutycycle > 1 || *userdutycycle <= 0 || *userdutycycle > 1 {
+		log.Println("-dutycycle and -userdutycycle must be above 0 and at most 1")
+		os.Exit(1)
+	}
+	server.Bitrate = *baud
+	server.DutyCycle = *dutycycle
+	server.UserDutyCycle = *userdutycycle
+	server.AirtimeBurst = *airburst
+	server.AirtimeMaxWait = *maxtxwait
+	server.CoalesceWindow = *coalesce
+	server.LocalCallsigns, err = irc.ParseCallsignPolicy(*callpolicy)
+	if err != nil {
+		log.Println("Invalid -callpolicy:", err)
+		os.Exit(1)
+	}
+	server.RemoteCallsigns, err = irc.ParseCallsignPolicy(*remotecallpolicy)
+	if err != nil {
+		log.Println("Invalid -remotecallpolicy:", err)
+		os.Exit(1)
+	}
+	server.ReceiveOnly = *rxonly
+	server.SetTransmitAllow(strings.Split(*txallow, ","))
+	server.SetOpers(strings.Split(*opers, ","))
+	if *passwd != "" {
+		server.Credentials, err = irc.LoadCredentials(*passwd)
+		if err != nil {
+			log.Println("Couldn't load credentials:", err)
+			os.Exit(1)
+		}
+	}
+	server.AuthPolicy, err = irc.ParseAuthPolicy(*authpolicy)
+	if err != nil {
+		log.Println("Invalid -authpolicy:", err)
+		os.Exit(1)
+	}
+	if *bouncer {
+		if *passwd == "" && *tlsclientca == "" {
+			log.Println("-bouncer needs -passwd or -tlsclientca so users can log in")
+			os.Exit(1)
+		}
+		server.Bouncer = true
+		server.BacklogLines = *backlog
+	}
+	if *history != "" {
+		server.History, err = irc.NewHistory(*history, *historylines)
+		if err != nil {
+			log.Println("Couldn't open history:", err)
+			os.Exit(1)
+		}
+		server.ReplayLines = *replay
+	}
+	if *signkeys != "" {
+		server.SigningKeys, err = irc.LoadSigningKeys(*signkeys)
+		if err != nil {
+			log.Println("Couldn't load signing keys:", err)
+			os.Exit(1)
+		}
+	}
+	if *keyring != "" {
+		server.Keyring, err = irc.LoadKeyring(*keyring)
+		if err != nil {
+			log.Println("Couldn't load keyring:", err)
+			os.Exit(1)
+		}
+	}
+	server.TNCParams, err = tncParams()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 	server.MOTD = func() string {
 		cmd := exec.Command("fortune")
 		if cmd.Err != nil {
@@ -54,7 +239,7 @@ func main() {
 		}
 		return string(out)
 	}
-	err := server.ConnectTNC(*tncaddr, *tncport)
+	err = server.ConnectTNC(*tncaddr, *tncport)
 	if err != nil {
 		log.Println(err)
 		return
@@ -70,6 +255,11 @@ func main() {
 	}()
 
 	defer func() {
+		if *exitkiss {
+			if err := server.ExitKISS(); err != nil {
+				log.Printf("Error taking TNC out of KISS mode: %s", err)
+			}
+		}
 		if *persist {
 			log.Printf("Performing final save...")
 			if err := server.Save(*statefile); err != nil {
@@ -79,7 +269,82 @@ func main() {
 		log.Printf("TTYL")
 	}()
 
+	if *tlsserve != "" {
+		config, err := tlsConfig()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if err := server.ListenTLS(*tlsserve, config); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
 	if err := server.Serve(*serve); err != nil {
 		log.Println(err)
 	}
 }
+
+func tncParams() (kiss.Params, error) {
+	if *persistence > 255 {
+		return kiss.Params{}, fmt.Errorf("invalid -persistence %d: must be 0-255", *persistence)
+	}
+	// only flags given on the command line are sent, so that zero
+	// values can be too
+	var params kiss.Params
+	p := uint8(*persistence)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "txdelay":
+			params.TXDelay = txdelay
+		case "persistence":
+			params.Persistence = &p
+		case "slottime":
+			params.SlotTime = slottime
+		case "txtail":
+			params.TXTail = txtail
+		case "fullduplex":
+			params.FullDuplex = fullduplex
+		}
+	})
+	if *sethardware == "" {
+		return params, nil
+	}
+	for _, field := range strings.Split(*sethardware, ",") {
+		hw, err := hex.DecodeString(strings.TrimSpace(field))
+		if err != nil {
+			return kiss.Params{}, fmt.Errorf("invalid -sethardware %q: %w", field, err)
+		}
+		params.Hardware = append(params.Hardware, hw)
+	}
+	return params, nil
+}
+
+func tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(*tlscert, *tlskey)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load -tlscert and -tlskey: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if *tlsclientca != "" {
+		pem, err := os.ReadFile(*tlsclientca)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read -tlsclientca: %w", err)
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in -tlsclientca %s", *tlsclientca)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if *tlsrequire {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if *tlsrequire {
+		return nil, fmt.Errorf("-tlsrequirecert needs a -tlsclientca bundle")
+	}
+	return config, nil
+}